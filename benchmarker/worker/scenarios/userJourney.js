@@ -63,9 +63,16 @@ export function userJourneyScenario() {
     fail("No session_id cookie received");
   }
 
+  const csrfArr = cookies["XSRF-TOKEN"] || [];
+  const csrfToken =
+    csrfArr.length > 0 && csrfArr[0] && csrfArr[0].value
+      ? csrfArr[0].value
+      : "";
+
   const headers = {
     "Content-Type": "application/json",
-    Cookie: `session_id=${sessionCookie}`,
+    Cookie: `session_id=${sessionCookie}; XSRF-TOKEN=${csrfToken}`,
+    "X-XSRF-TOKEN": csrfToken,
   };
 
   // Step 2: 商品一覧表示
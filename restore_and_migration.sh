@@ -44,25 +44,19 @@ else
     echo "リストアに成功しました。"
 fi
 
-next="0"
-migrationDir="./mysql/migration"
-
+# マイグレーションはバックエンドのバイナリに埋め込まれている
+# 適用履歴は schema_migrations テーブルに残り、適用済みのものは再実行されない
+if [[ $HOSTNAME == ftt2508-* ]]; then
+    serverBin="/usr/local/bin/server"
+else
+    serverBin="/app/server"
+fi
 
 echo "MySQLのマイグレーションを開始します。"
-while :
-do
-    fileName=$(cd $migrationDir && ls ${next}_*.sql 2>/dev/null)
-    if [ ! $fileName ]; then
-        echo "マイグレーションに成功しました。"
-        break
-    fi
-
-    echo "${fileName}を適用します..."
-    docker exec tuning-mysql bash -c "mysql -u root -pmysql 42Tokyo2508-db < /etc/mysql/migration/${fileName}"
-    next=$(($next + 1))
-done
-
+docker exec tuning-backend "$serverBin" migrate up
 if [ $? -ne 0 ]; then
     echo "リストアとマイグレーションに失敗しました。"
     exit 1
 fi
+docker exec tuning-backend "$serverBin" migrate status
+echo "マイグレーションに成功しました。"
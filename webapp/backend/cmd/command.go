@@ -0,0 +1,32 @@
+package main
+
+import (
+	"backend/internal/config"
+	"backend/internal/db"
+	"backend/internal/logging"
+	"log/slog"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// サブコマンド共通の準備。設定を読み、ロガーと DB 接続を作る。失敗したらログを出して false を返す
+// 標準出力は結果の表示に使うので、ログは標準エラーに出す
+func setupCommand() (*slog.Logger, *sqlx.DB, bool) {
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return nil, nil, false
+	}
+	logger, err := logging.New(os.Stderr, cfg.Log)
+	if err != nil {
+		slog.Error("failed to initialize logger", "error", err)
+		return nil, nil, false
+	}
+	dbConn, err := db.InitDBConnection(cfg.Database, cfg.Telemetry, logger)
+	if err != nil {
+		logger.Error("failed to connect to database", "error", err)
+		return nil, nil, false
+	}
+	return logger, dbConn, true
+}
@@ -1,22 +1,66 @@
 package main
 
 import (
+	"backend/internal/config"
+	"backend/internal/logging"
 	"backend/internal/server"
-	"log"
+	"backend/internal/telemetry"
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/kaz/pprotein/integration/standalone"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "shipping-cache":
+			os.Exit(runShippingCache(os.Args[2:]))
+		}
+	}
+
 	go standalone.Integrate(":19001")
 
-	srv, dbConn, err := server.NewServer()
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// ログも OpenTelemetry に送れるよう、ロガーより先に初期化する
+	tel, err := telemetry.Init(ctx, cfg.Telemetry)
 	if err != nil {
-		log.Fatalf("Failed to initialize server: %v", err)
+		slog.Error("failed to initialize telemetry", "error", err)
+		os.Exit(1)
 	}
-	if dbConn != nil {
-		defer dbConn.Close()
+
+	logger, err := logging.New(os.Stdout, cfg.Log, tel.LogHandler())
+	if err != nil {
+		slog.Error("failed to initialize logger", "error", err)
+		os.Exit(1)
+	}
+	// ロガーを受け取らないライブラリや標準の log パッケージの出力もそろえる
+	slog.SetDefault(logger)
+	logger.Info("effective configuration", "config", cfg.Redacted())
+
+	srv, err := server.NewServer(cfg, logger, tel)
+	if err != nil {
+		logger.Error("failed to initialize server", "error", err)
+		_ = tel.Shutdown(context.Background())
+		os.Exit(1)
 	}
 
-	srv.Run()
+	if err := srv.Run(ctx); err != nil {
+		stop()
+		logger.Error("server exited with error", "error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"backend/internal/metrics"
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+)
+
+const shippingCacheUsage = `usage: server shipping-cache <command>
+
+commands:
+  check       report orders whose shipping_order_cache row is missing, stale or orphaned
+              (exits with 3 when drift is found)
+  repair      fix the drift reported by check`
+
+// 表示する注文IDの上限
+const maxPrintedDriftIDs = 50
+
+// shipping-cache サブコマンド。終了コードを返す
+func runShippingCache(args []string) int {
+	if len(args) != 1 || (args[0] != "check" && args[0] != "repair") {
+		fmt.Fprintln(os.Stderr, shippingCacheUsage)
+		return 2
+	}
+
+	logger, dbConn, ok := setupCommand()
+	if !ok {
+		return 1
+	}
+	defer dbConn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store := repository.NewStore(dbConn, nil, logger)
+	defer store.Close()
+	svc := service.NewShippingCacheService(store, logger, metrics.New())
+
+	if args[0] == "check" {
+		drift, err := svc.Check(ctx)
+		if err != nil {
+			logger.Error("shipping_order_cache check failed", "error", err)
+			return 1
+		}
+		printDrift(drift)
+		if drift.Total() > 0 {
+			return 3
+		}
+		return 0
+	}
+
+	drift, repaired, err := svc.Repair(ctx)
+	if err != nil {
+		logger.Error("shipping_order_cache repair failed", "error", err)
+		return 1
+	}
+	printDrift(drift)
+	fmt.Printf("repaired %d rows\n", repaired)
+	return 0
+}
+
+func printDrift(drift model.ShippingCacheDrift) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tORDERS\tORDER IDS")
+	for _, d := range []struct {
+		kind string
+		ids  []int64
+	}{
+		{"missing", drift.Missing},
+		{"stale", drift.Stale},
+		{"orphaned", drift.Orphaned},
+	} {
+		ids := fmt.Sprint(d.ids[:min(len(d.ids), maxPrintedDriftIDs)])
+		if len(d.ids) > maxPrintedDriftIDs {
+			ids += " ..."
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", d.kind, len(d.ids), ids)
+	}
+	w.Flush()
+}
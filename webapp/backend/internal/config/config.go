@@ -0,0 +1,538 @@
+// アプリケーションの設定をまとめて読み込む
+// 既定値 → CONFIG_FILE で指定した JSON ファイル → 環境変数 の順に上書きする
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/goccy/go-json"
+)
+
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	Database DatabaseConfig `json:"database"`
+	Timeouts TimeoutsConfig `json:"timeouts"`
+	Auth     AuthConfig     `json:"auth"`
+	Images   ImagesConfig   `json:"images"`
+	// shipping_order_cache の定期チェック
+	ShippingCache ShippingCacheConfig `json:"shipping_cache"`
+	// 商品一覧のキャッシュ
+	ProductCache ProductCacheConfig `json:"product_cache"`
+	Telemetry    TelemetryConfig    `json:"telemetry"`
+	Log          LogConfig          `json:"log"`
+}
+
+type ServerConfig struct {
+	Port string `json:"port"`
+	// http.Server に設定するタイムアウト
+	ReadTimeout       Duration `json:"read_timeout"`
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	// API リクエスト全体の処理時間の上限。超えると 504 を返す
+	HandlerTimeout Duration `json:"handler_timeout"`
+	// シャットダウン全体の上限。処理中のリクエストを待ち、残りでテレメトリを送り切る
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	// ShutdownTimeout のうちテレメトリの送信に取っておく時間
+	// リクエストの待ちで使い切られてトレースが捨てられないよう、別の期限で送る
+	TelemetryShutdownTimeout Duration `json:"telemetry_shutdown_timeout"`
+	// ヘルスチェックを失敗させてからドレインを始めるまでの待ち時間
+	ShutdownDrainDelay Duration `json:"shutdown_drain_delay"`
+	HealthCheckTimeout Duration `json:"health_check_timeout"`
+}
+
+type DatabaseConfig struct {
+	URL             string   `json:"url"`
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+	PingTimeout     Duration `json:"ping_timeout"`
+	// 読み取り用レプリカの DSN。空ならすべてプライマリで処理する
+	ReplicaURL string `json:"replica_url"`
+	// レプリカの死活監視の間隔。応答がない間はプライマリで読む
+	ReplicaCheckInterval Duration `json:"replica_check_interval"`
+}
+
+// 処理ごとの制限時間。0 の項目は Default を使う。超えると 504 を返す
+type TimeoutsConfig struct {
+	Default           Duration `json:"default"`
+	Login             Duration `json:"login"`
+	FetchOrders       Duration `json:"fetch_orders"`
+	FetchProducts     Duration `json:"fetch_products"`
+	CreateOrders      Duration `json:"create_orders"`
+	DeliveryPlan      Duration `json:"delivery_plan"`
+	UpdateOrderStatus Duration `json:"update_order_status"`
+}
+
+func (t *TimeoutsConfig) fillDefaults() {
+	for _, d := range []*Duration{&t.Login, &t.FetchOrders, &t.FetchProducts, &t.CreateOrders, &t.DeliveryPlan, &t.UpdateOrderStatus} {
+		if *d == 0 {
+			*d = t.Default
+		}
+	}
+}
+
+type AuthConfig struct {
+	RobotAPIKey    string `json:"robot_api_key"`
+	CookieSecure   bool   `json:"cookie_secure"`
+	CookieSameSite string `json:"cookie_same_site"`
+	CSRFEnabled    bool   `json:"csrf_enabled"`
+}
+
+type ImagesConfig struct {
+	Dir            string `json:"dir"`
+	CacheBytes     int64  `json:"cache_bytes"`
+	CacheItemBytes int64  `json:"cache_item_bytes"`
+}
+
+type ShippingCacheConfig struct {
+	// orders とのずれを調べる間隔。0 なら定期チェックをしない
+	CheckInterval Duration `json:"check_interval"`
+	// ずれが見つかったら直す。false なら報告だけする
+	AutoRepair bool `json:"auto_repair"`
+}
+
+type ProductCacheConfig struct {
+	// キャッシュした一覧を使う期間。0 ならキャッシュしない
+	TTL Duration `json:"ttl"`
+	// 検索結果をキャッシュする条件の数の上限
+	MaxSearches int `json:"max_searches"`
+}
+
+// シグナル(トレース・メトリクス・ログ)ごとのエクスポーター
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+type TelemetryConfig struct {
+	// トレースを有効にするか。未指定の場合はエクスポーターが設定されていれば有効にする
+	Enabled *bool `json:"enabled"`
+	// otlp / stdout / file / none。トレースは未指定なら OTLP エンドポイントがあれば otlp
+	TracesExporter  string `json:"traces_exporter"`
+	MetricsExporter string `json:"metrics_exporter"`
+	LogsExporter    string `json:"logs_exporter"`
+	// "host:port" または "http://host:port"。パスはシグナルごとの既定値を使う
+	OTLPEndpoint string `json:"otlp_endpoint"`
+	// http/protobuf または grpc
+	OTLPProtocol string `json:"otlp_protocol"`
+	// file エクスポーターの出力先。シグナルごとに traces.jsonl のようなファイルを作る
+	FileDir     string   `json:"file_dir"`
+	SampleRatio *float64 `json:"sample_ratio"`
+	Sampler     string   `json:"sampler"`
+	ServiceName string   `json:"service_name"`
+	Environment string   `json:"environment"`
+}
+
+// トレースのエクスポーター。無効の場合は ExporterNone
+func (t TelemetryConfig) TraceExporter() string {
+	if t.Enabled != nil && !*t.Enabled {
+		return ExporterNone
+	}
+	if t.TracesExporter != "" {
+		return t.TracesExporter
+	}
+	if t.OTLPEndpoint != "" {
+		return ExporterOTLP
+	}
+	if t.Enabled != nil && *t.Enabled {
+		// 有効にしたがエクスポート先がない場合は標準出力に出す
+		return ExporterStdout
+	}
+	return ExporterNone
+}
+
+func (t TelemetryConfig) IsEnabled() bool {
+	return t.TraceExporter() != ExporterNone
+}
+
+type LogConfig struct {
+	// debug / info / warn / error
+	Level string `json:"level"`
+	// json / text
+	Format string `json:"format"`
+	// 成功したリクエストでもこれ以上かかったものはアクセスログを info で出す。0 なら出さない
+	SlowRequestThreshold Duration `json:"slow_request_threshold"`
+}
+
+// JSON では "5s" のような文字列で書ける time.Duration
+type Duration time.Duration
+
+func (d Duration) Std() time.Duration { return time.Duration(d) }
+
+func (d Duration) String() string { return time.Duration(d).String() }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:              "8080",
+			ReadTimeout:       Duration(30 * time.Second),
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			WriteTimeout:      Duration(65 * time.Second),
+			IdleTimeout:       Duration(120 * time.Second),
+			HandlerTimeout:    Duration(60 * time.Second),
+			ShutdownTimeout:   Duration(8 * time.Second),
+			// 送信先が応答しないときに残りの後始末を止めないよう短くする
+			TelemetryShutdownTimeout: Duration(2 * time.Second),
+			HealthCheckTimeout:       Duration(2 * time.Second),
+		},
+		Timeouts: TimeoutsConfig{
+			Default:      Duration(10 * time.Second),
+			DeliveryPlan: Duration(30 * time.Second),
+		},
+		Database: DatabaseConfig{
+			URL:                  "user:password@tcp(db:4306)/42Tokyo2508-db",
+			MaxOpenConns:         25,
+			MaxIdleConns:         10,
+			PingTimeout:          Duration(5 * time.Second),
+			ReplicaCheckInterval: Duration(5 * time.Second),
+		},
+		Auth: AuthConfig{
+			RobotAPIKey:    "test-robot-key",
+			CookieSameSite: "lax",
+			CSRFEnabled:    true,
+		},
+		Images: ImagesConfig{
+			Dir:            "/app/images",
+			CacheBytes:     32 << 20,
+			CacheItemBytes: 256 << 10,
+		},
+		ShippingCache: ShippingCacheConfig{
+			AutoRepair: true,
+		},
+		ProductCache: ProductCacheConfig{
+			TTL:         Duration(time.Minute),
+			MaxSearches: 1024,
+		},
+		Telemetry: TelemetryConfig{
+			MetricsExporter: ExporterNone,
+			LogsExporter:    ExporterNone,
+			OTLPProtocol:    "http/protobuf",
+			FileDir:         "telemetry",
+			ServiceName:     "backend",
+			Environment:     "local",
+		},
+		Log: LogConfig{
+			Level:                "info",
+			Format:               "json",
+			SlowRequestThreshold: Duration(time.Second),
+		},
+	}
+}
+
+// 設定を読み込んで検証する
+func Load() (*Config, error) {
+	cfg := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	cfg.Timeouts.fillDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok && v != "" {
+			*dst = v
+		}
+	}
+	integer := func(key string, dst *int) {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	integer64 := func(key string, dst *int64) {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v := os.Getenv(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = b
+		}
+	}
+	duration := func(key string, dst *Duration) {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = Duration(d)
+		}
+	}
+
+	str("PORT", &c.Server.Port)
+	duration("SERVER_READ_TIMEOUT", &c.Server.ReadTimeout)
+	duration("SERVER_READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout)
+	duration("SERVER_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	duration("SERVER_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	duration("SERVER_HANDLER_TIMEOUT", &c.Server.HandlerTimeout)
+	duration("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+	duration("TELEMETRY_SHUTDOWN_TIMEOUT", &c.Server.TelemetryShutdownTimeout)
+	duration("SHUTDOWN_DRAIN_DELAY", &c.Server.ShutdownDrainDelay)
+	duration("HEALTH_CHECK_TIMEOUT", &c.Server.HealthCheckTimeout)
+
+	duration("TIMEOUT_DEFAULT", &c.Timeouts.Default)
+	duration("TIMEOUT_LOGIN", &c.Timeouts.Login)
+	duration("TIMEOUT_FETCH_ORDERS", &c.Timeouts.FetchOrders)
+	duration("TIMEOUT_FETCH_PRODUCTS", &c.Timeouts.FetchProducts)
+	duration("TIMEOUT_CREATE_ORDERS", &c.Timeouts.CreateOrders)
+	duration("TIMEOUT_DELIVERY_PLAN", &c.Timeouts.DeliveryPlan)
+	duration("TIMEOUT_UPDATE_ORDER_STATUS", &c.Timeouts.UpdateOrderStatus)
+
+	str("DATABASE_URL", &c.Database.URL)
+	integer("DB_MAX_OPEN_CONNS", &c.Database.MaxOpenConns)
+	integer("DB_MAX_IDLE_CONNS", &c.Database.MaxIdleConns)
+	duration("DB_CONN_MAX_LIFETIME", &c.Database.ConnMaxLifetime)
+	duration("DB_PING_TIMEOUT", &c.Database.PingTimeout)
+	str("DATABASE_REPLICA_URL", &c.Database.ReplicaURL)
+	duration("DB_REPLICA_CHECK_INTERVAL", &c.Database.ReplicaCheckInterval)
+
+	str("ROBOT_API_KEY", &c.Auth.RobotAPIKey)
+	boolean("SESSION_COOKIE_SECURE", &c.Auth.CookieSecure)
+	str("SESSION_COOKIE_SAMESITE", &c.Auth.CookieSameSite)
+	boolean("CSRF_ENABLED", &c.Auth.CSRFEnabled)
+
+	str("IMAGE_DIR", &c.Images.Dir)
+	integer64("IMAGE_CACHE_BYTES", &c.Images.CacheBytes)
+	integer64("IMAGE_CACHE_ITEM_BYTES", &c.Images.CacheItemBytes)
+
+	duration("SHIPPING_CACHE_CHECK_INTERVAL", &c.ShippingCache.CheckInterval)
+	boolean("SHIPPING_CACHE_AUTO_REPAIR", &c.ShippingCache.AutoRepair)
+
+	duration("PRODUCT_CACHE_TTL", &c.ProductCache.TTL)
+	integer("PRODUCT_CACHE_MAX_SEARCHES", &c.ProductCache.MaxSearches)
+
+	if v := os.Getenv("TRACE_ENABLED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("TRACE_ENABLED: %w", err))
+		} else {
+			c.Telemetry.Enabled = &b
+		}
+	}
+	str("OTEL_TRACES_EXPORTER", &c.Telemetry.TracesExporter)
+	str("OTEL_METRICS_EXPORTER", &c.Telemetry.MetricsExporter)
+	str("OTEL_LOGS_EXPORTER", &c.Telemetry.LogsExporter)
+	str("OTEL_EXPORTER_OTLP_ENDPOINT", &c.Telemetry.OTLPEndpoint)
+	str("OTEL_EXPORTER_OTLP_PROTOCOL", &c.Telemetry.OTLPProtocol)
+	str("OTEL_EXPORTER_FILE_DIR", &c.Telemetry.FileDir)
+	if v := os.Getenv("TRACE_SAMPLE_RATIO"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("TRACE_SAMPLE_RATIO: %w", err))
+		} else {
+			c.Telemetry.SampleRatio = &r
+		}
+	}
+	str("OTEL_TRACES_SAMPLER", &c.Telemetry.Sampler)
+	str("SERVICE_NAME", &c.Telemetry.ServiceName)
+	str("GO_ENV", &c.Telemetry.Environment)
+	str("ENV", &c.Telemetry.Environment)
+
+	str("LOG_LEVEL", &c.Log.Level)
+	str("LOG_FORMAT", &c.Log.Format)
+	duration("LOG_SLOW_REQUEST_THRESHOLD", &c.Log.SlowRequestThreshold)
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if p, err := strconv.Atoi(c.Server.Port); err != nil || p <= 0 || p > 65535 {
+		errs = append(errs, fmt.Errorf("server.port must be a valid port number: %q", c.Server.Port))
+	}
+	if c.Server.ShutdownTimeout < 0 || c.Server.ShutdownDrainDelay < 0 || c.Server.TelemetryShutdownTimeout < 0 {
+		errs = append(errs, errors.New("server shutdown durations must not be negative"))
+	}
+	if c.Server.TelemetryShutdownTimeout >= c.Server.ShutdownTimeout && c.Server.ShutdownTimeout > 0 {
+		errs = append(errs, errors.New("server.telemetry_shutdown_timeout must be shorter than server.shutdown_timeout"))
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.ReadHeaderTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 || c.Server.HandlerTimeout < 0 {
+		errs = append(errs, errors.New("server timeouts must not be negative"))
+	}
+	// 書き込みタイムアウトが先に来ると 504 を返す前に接続が切られる
+	if c.Server.WriteTimeout > 0 && c.Server.HandlerTimeout > 0 && c.Server.WriteTimeout <= c.Server.HandlerTimeout {
+		errs = append(errs, errors.New("server.write_timeout must be longer than server.handler_timeout"))
+	}
+	if c.Timeouts.Default <= 0 {
+		errs = append(errs, errors.New("timeouts.default must be positive"))
+	}
+	if c.Server.HealthCheckTimeout <= 0 {
+		errs = append(errs, errors.New("server.health_check_timeout must be positive"))
+	}
+	if c.Database.URL == "" {
+		errs = append(errs, errors.New("database.url is required"))
+	} else if _, err := mysql.ParseDSN(c.Database.URL); err != nil {
+		errs = append(errs, fmt.Errorf("database.url is invalid: %w", err))
+	}
+	if c.Database.MaxOpenConns <= 0 {
+		errs = append(errs, errors.New("database.max_open_conns must be positive"))
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, errors.New("database.max_idle_conns must be between 0 and max_open_conns"))
+	}
+	if c.Database.PingTimeout <= 0 {
+		errs = append(errs, errors.New("database.ping_timeout must be positive"))
+	}
+	if c.Database.ReplicaURL != "" {
+		if _, err := mysql.ParseDSN(c.Database.ReplicaURL); err != nil {
+			errs = append(errs, fmt.Errorf("database.replica_url is invalid: %w", err))
+		}
+		if c.Database.ReplicaCheckInterval <= 0 {
+			errs = append(errs, errors.New("database.replica_check_interval must be positive"))
+		}
+	}
+	if c.Auth.RobotAPIKey == "" {
+		errs = append(errs, errors.New("auth.robot_api_key is required"))
+	}
+	switch strings.ToLower(c.Auth.CookieSameSite) {
+	case "lax", "strict", "none":
+	default:
+		errs = append(errs, fmt.Errorf("auth.cookie_same_site must be lax, strict or none: %q", c.Auth.CookieSameSite))
+	}
+	if c.Images.Dir == "" {
+		errs = append(errs, errors.New("images.dir is required"))
+	}
+	if c.Images.CacheBytes < 0 || c.Images.CacheItemBytes < 0 {
+		errs = append(errs, errors.New("images cache sizes must not be negative"))
+	}
+	if c.ShippingCache.CheckInterval < 0 {
+		errs = append(errs, errors.New("shipping_cache.check_interval must not be negative"))
+	}
+	if c.ProductCache.TTL < 0 {
+		errs = append(errs, errors.New("product_cache.ttl must not be negative"))
+	}
+	if c.ProductCache.MaxSearches < 0 {
+		errs = append(errs, errors.New("product_cache.max_searches must not be negative"))
+	}
+	if r := c.Telemetry.SampleRatio; r != nil && (*r < 0 || *r > 1) {
+		errs = append(errs, fmt.Errorf("telemetry.sample_ratio must be between 0 and 1: %v", *r))
+	}
+	usesOTLP, usesFile := false, false
+	for _, e := range []struct{ name, exporter string }{
+		{"traces_exporter", c.Telemetry.TracesExporter},
+		{"metrics_exporter", c.Telemetry.MetricsExporter},
+		{"logs_exporter", c.Telemetry.LogsExporter},
+	} {
+		switch e.exporter {
+		case "", ExporterNone, ExporterStdout:
+		case ExporterOTLP:
+			usesOTLP = true
+		case ExporterFile:
+			usesFile = true
+		default:
+			errs = append(errs, fmt.Errorf("telemetry.%s must be otlp, stdout, file or none: %q", e.name, e.exporter))
+		}
+	}
+	if usesOTLP && c.Telemetry.OTLPEndpoint == "" {
+		errs = append(errs, errors.New("telemetry.otlp_endpoint is required for the otlp exporter"))
+	}
+	if usesFile && c.Telemetry.FileDir == "" {
+		errs = append(errs, errors.New("telemetry.file_dir is required for the file exporter"))
+	}
+	switch c.Telemetry.OTLPProtocol {
+	case "http/protobuf", "grpc":
+	default:
+		errs = append(errs, fmt.Errorf("telemetry.otlp_protocol must be http/protobuf or grpc: %q", c.Telemetry.OTLPProtocol))
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level must be debug, info, warn or error: %q", c.Log.Level))
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "json", "text":
+	default:
+		errs = append(errs, fmt.Errorf("log.format must be json or text: %q", c.Log.Format))
+	}
+	if c.Log.SlowRequestThreshold < 0 {
+		errs = append(errs, errors.New("log.slow_request_threshold must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// パスワードや API キーを伏せた設定を JSON で返す。起動時のログ出力用
+func (c *Config) Redacted() string {
+	r := *c
+	r.Database.URL = RedactDSN(c.Database.URL)
+	if c.Database.ReplicaURL != "" {
+		r.Database.ReplicaURL = RedactDSN(c.Database.ReplicaURL)
+	}
+	if r.Auth.RobotAPIKey != "" {
+		r.Auth.RobotAPIKey = redacted
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("<failed to marshal config: %v>", err)
+	}
+	return string(b)
+}
+
+const redacted = "***"
+
+// DSN のパスワード部分を伏せる
+func RedactDSN(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return redacted
+	}
+	if cfg.Passwd != "" {
+		cfg.Passwd = redacted
+	}
+	return cfg.FormatDSN()
+}
@@ -1,45 +1,64 @@
 package db
 
 import (
+	"backend/internal/config"
 	"backend/internal/telemetry"
 	"context"
 	"fmt"
-	"log"
-	"os"
-	"time"
+	"log/slog"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
-func InitDBConnection() (*sqlx.DB, error) {
-	dbUrl := os.Getenv("DATABASE_URL")
-	if dbUrl == "" {
-		dbUrl = "user:password@tcp(db:4306)/42Tokyo2508-db"
-	}
-	dsn := fmt.Sprintf("%s?charset=utf8mb4&parseTime=True&loc=Local", dbUrl)
-	log.Printf(dsn)
-
-	driverName := telemetry.WrapSQLDriver("mysql")
-	dbConn, err := sqlx.Open(driverName, dsn)
+func InitDBConnection(cfg config.DatabaseConfig, telemetryCfg config.TelemetryConfig, logger *slog.Logger) (*sqlx.DB, error) {
+	dbConn, err := open(cfg.URL, telemetryCfg, logger)
 	if err != nil {
-		log.Printf("Failed to open database connection: %v", err)
-		return nil, fmt.Errorf("failed to open database connection: %w", err)
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.PingTimeout.Std())
 	defer cancel()
 	err = dbConn.PingContext(ctx)
 	if err != nil {
 		dbConn.Close()
-		log.Printf("Failed to connect to database: %v", err)
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	log.Println("Successfully connected to MySQL!")
+	logger.Info("connected to database")
+
+	setPool(dbConn, cfg)
+
+	return dbConn, nil
+}
+
+// 読み取り用レプリカに接続する。レプリカが設定されていなければ nil を返す
+// 起動時にレプリカが応答しなくても失敗にはせず、死活監視に任せる
+func InitReplicaConnection(cfg config.DatabaseConfig, telemetryCfg config.TelemetryConfig, logger *slog.Logger) (*sqlx.DB, error) {
+	if cfg.ReplicaURL == "" {
+		return nil, nil
+	}
+	dbConn, err := open(cfg.ReplicaURL, telemetryCfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	setPool(dbConn, cfg)
+	return dbConn, nil
+}
 
-	dbConn.SetMaxOpenConns(25)
-	dbConn.SetMaxIdleConns(10)
-	dbConn.SetConnMaxLifetime(0)
+func open(url string, telemetryCfg config.TelemetryConfig, logger *slog.Logger) (*sqlx.DB, error) {
+	dsn := fmt.Sprintf("%s?charset=utf8mb4&parseTime=True&loc=Local", url)
+	logger.Info("connecting to database", "dsn", config.RedactDSN(dsn))
 
+	driverName := telemetry.WrapSQLDriver("mysql", telemetryCfg)
+	dbConn, err := sqlx.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
 	return dbConn, nil
 }
+
+func setPool(dbConn *sqlx.DB, cfg config.DatabaseConfig) {
+	dbConn.SetMaxOpenConns(cfg.MaxOpenConns)
+	dbConn.SetMaxIdleConns(cfg.MaxIdleConns)
+	dbConn.SetConnMaxLifetime(cfg.ConnMaxLifetime.Std())
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"backend/internal/apperror"
+	"backend/internal/imagestore"
+	"backend/internal/model"
+	"backend/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
+)
+
+// アップロードできる画像の最大サイズ
+const maxImageUploadSize = 10 << 20
+
+type AdminProductHandler struct {
+	base
+	ProductSvc *service.ProductService
+	Images     *imagestore.Store
+}
+
+func NewAdminProductHandler(svc *service.ProductService, images *imagestore.Store, logger *slog.Logger) *AdminProductHandler {
+	return &AdminProductHandler{base: base{logger: logger}, ProductSvc: svc, Images: images}
+}
+
+// 商品を1件取得
+func (h *AdminProductHandler) Get(w http.ResponseWriter, r *http.Request) {
+	productID, ok := h.productIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := h.ProductSvc.GetProduct(r.Context(), productID)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
+
+// 商品を作成
+func (h *AdminProductHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req model.ProductRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	product, err := h.ProductSvc.CreateProduct(r.Context(), req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(product)
+}
+
+// 商品を更新
+func (h *AdminProductHandler) Update(w http.ResponseWriter, r *http.Request) {
+	productID, ok := h.productIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req model.ProductRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	product, err := h.ProductSvc.UpdateProduct(r.Context(), productID, req)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
+
+// 商品を削除
+func (h *AdminProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	productID, ok := h.productIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.ProductSvc.DeleteProduct(r.Context(), productID); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 商品画像をアップロードし、商品に紐づける
+// multipart/form-data の image フィールドで受け取る
+func (h *AdminProductHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
+	productID, ok := h.productIDParam(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.ProductSvc.GetProduct(r.Context(), productID); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageUploadSize)
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			h.writeError(w, r, apperror.Wrap(apperror.CodeRequestTooLarge, "Uploaded image is too large", err))
+			return
+		}
+		h.writeError(w, r, apperror.Wrap(apperror.CodeBadRequest, "Form field 'image' is required", err))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		h.writeError(w, r, apperror.Wrap(apperror.CodeBadRequest, "Failed to read uploaded image", err))
+		return
+	}
+
+	// 形式の判定は拡張子やContent-Typeではなく中身で行う
+	name, err := h.Images.Save(fmt.Sprintf("product_%d", productID), data)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	if err := h.ProductSvc.SetProductImage(r.Context(), productID, name); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+	h.logger.InfoContext(r.Context(), "product image uploaded", "product_id", productID, "image", name, "bytes", len(data))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"image": name})
+}
+
+// キャッシュの統計を返す
+func (h *AdminProductHandler) CacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"product_catalog": h.ProductSvc.CatalogStats()})
+}
+
+func (h *AdminProductHandler) productIDParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productID"))
+	if err != nil || productID <= 0 {
+		h.writeError(w, r, apperror.Wrap(apperror.CodeBadRequest, "Invalid product ID", err))
+		return 0, false
+	}
+	return productID, true
+}
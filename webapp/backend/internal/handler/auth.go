@@ -1,40 +1,49 @@
 package handler
 
 import (
-	"errors"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
 	"github.com/goccy/go-json"
 )
 
+// ログイン時に発行するCookieの属性
+type CookieConfig struct {
+	Secure   bool
+	SameSite http.SameSite
+}
+
 type AuthHandler struct {
+	base
 	AuthSvc *service.AuthService
+	Cookie  CookieConfig
 }
 
-func NewAuthHandler(authSvc *service.AuthService) *AuthHandler {
-	return &AuthHandler{AuthSvc: authSvc}
+func NewAuthHandler(authSvc *service.AuthService, cookie CookieConfig, logger *slog.Logger) *AuthHandler {
+	return &AuthHandler{base: base{logger: logger}, AuthSvc: authSvc, Cookie: cookie}
 }
 
 // ログイン時にセッションを発行し、Cookieにセットする
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
-	log.Println("-> Received request for /api/login")
-
 	var req model.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(w, r, &req); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
 	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password)
 	if err != nil {
-		if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword) {
-			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
-		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+		h.writeError(w, r, err)
+		return
+	}
+
+	csrfToken, err := middleware.NewCSRFToken()
+	if err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -43,10 +52,52 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		Value:    sessionID,
 		Expires:  expiresAt,
 		HttpOnly: true,
+		Secure:   h.Cookie.Secure,
+		SameSite: h.Cookie.SameSite,
 		Path:     "/",
 	})
+	// フロントエンドからヘッダーに載せ替えられるよう HttpOnly にはしない
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.CSRFCookieName,
+		Value:    csrfToken,
+		Expires:  expiresAt,
+		Secure:   h.Cookie.Secure,
+		SameSite: h.Cookie.SameSite,
+		Path:     "/",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":    "Login successful",
+		"csrf_token": csrfToken,
+	})
+}
+
+// ログインしてセッションIDを Bearer トークンとして返す
+// Cookie を扱いにくいモバイルアプリや CLI 向け
+func (h *AuthHandler) LoginToken(w http.ResponseWriter, r *http.Request) {
+	var req model.LoginRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	resp := model.TokenResponse{
+		AccessToken: sessionID,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(expiresAt).Seconds()),
+		ExpiresAt:   expiresAt,
+	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
+	json.NewEncoder(w).Encode(resp)
 }
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"backend/internal/apperror"
+	"backend/internal/imagestore"
+	"backend/internal/service"
+	"backend/internal/service/utils"
+	"backend/internal/validation"
+)
+
+// ドメインのエラーを API のエラーに変換する
+// ハンドラーはエラーを直接 http.Error で返さず、必ず writeError を通すこと
+func toAppError(err error) *apperror.Error {
+	var appErr *apperror.Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var verrs validation.Errors
+	if errors.As(err, &verrs) {
+		return apperror.Wrap(apperror.CodeValidation, "Request validation failed", err).WithDetails(verrs)
+	}
+
+	switch {
+	case errors.Is(err, service.ErrUserNotFound), errors.Is(err, service.ErrInvalidPassword):
+		// ユーザーの有無を推測されないよう同じメッセージにする
+		return apperror.Wrap(apperror.CodeUnauthorized, "Invalid credentials", err)
+	case errors.Is(err, service.ErrProductNotFound):
+		return apperror.Wrap(apperror.CodeNotFound, "Product not found", err)
+	case errors.Is(err, service.ErrInvalidProduct):
+		return apperror.Wrap(apperror.CodeValidation, "Name is required and weight must be positive", err)
+	case errors.Is(err, service.ErrPlanConflict):
+		return apperror.Wrap(apperror.CodeUnavailable, "Orders were taken by other robots; please retry", err)
+	case errors.Is(err, imagestore.ErrInvalidPath):
+		return apperror.Wrap(apperror.CodeBadRequest, "Invalid image path", err)
+	case errors.Is(err, imagestore.ErrInvalidSize):
+		return apperror.Wrap(apperror.CodeBadRequest, "Invalid image size", err)
+	case errors.Is(err, imagestore.ErrNotFound):
+		return apperror.Wrap(apperror.CodeNotFound, "Image not found", err)
+	case errors.Is(err, imagestore.ErrTooLarge):
+		return apperror.Wrap(apperror.CodeRequestTooLarge, "Image dimensions are too large", err)
+	case errors.Is(err, imagestore.ErrUnsupportedImage):
+		return apperror.Wrap(apperror.CodeUnsupportedMediaType, "Unsupported image type", err)
+	case errors.Is(err, utils.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return apperror.Wrap(apperror.CodeTimeout, "The operation timed out", err)
+	case errors.Is(err, context.Canceled):
+		return apperror.Wrap(apperror.CodeUnavailable, "The request was canceled", err)
+	default:
+		return apperror.Wrap(apperror.CodeInternal, "Internal server error", err)
+	}
+}
+
+// 各ハンドラーに埋め込む共通部分
+type base struct {
+	logger *slog.Logger
+}
+
+// エラーを JSON で返す。5xx の場合は原因をログに残す
+func (b base) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := toAppError(err)
+	if appErr.HTTPStatus() >= http.StatusInternalServerError {
+		b.logger.ErrorContext(r.Context(), "request failed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"code", appErr.Code,
+			"error", err,
+		)
+	}
+	apperror.Write(w, r, appErr)
+}
+
+// リクエストボディの JSON が読めなかった場合のエラー
+func invalidBody(err error) *apperror.Error {
+	return apperror.Wrap(apperror.CodeBadRequest, "Invalid request body", err)
+}
+
+// 認証ミドルウェアを通っていない場合のエラー
+var errNoUserInContext = apperror.New(apperror.CodeInternal, "User not found in context")
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/internal/metrics"
+	"backend/internal/middleware"
+	"backend/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
+)
+
+// どのエラーも同じ JSON 形式で、レスポンスヘッダーと同じリクエストIDを載せて返す
+func TestErrorEnvelope(t *testing.T) {
+	store := newSQLiteStore(t)
+	orders := NewOrderHandler(service.NewOrderService(store, testTimeouts()), discardLogger)
+	admin := NewAdminProductHandler(service.NewProductService(store, testTimeouts(), discardLogger, metrics.New()), nil, discardLogger)
+	adminRouter := chi.NewRouter()
+	adminRouter.Get("/api/admin/products/{productID}", admin.Get)
+
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		method      string
+		path        string
+		body        string
+		wantStatus  int
+		wantCode    string
+		wantDetails bool
+	}{
+		{"timeout", middleware.HandlerTimeout(time.Nanosecond)(http.HandlerFunc(orders.List)).ServeHTTP,
+			http.MethodPost, "/api/v1/orders", `{}`, http.StatusGatewayTimeout, "timeout", false},
+		{"validation", orders.List,
+			http.MethodPost, "/api/v1/orders", `{"sort_field":"user_id"}`, http.StatusBadRequest, "validation_failed", true},
+		{"not found", adminRouter.ServeHTTP,
+			http.MethodGet, "/api/admin/products/99", "", http.StatusNotFound, "not_found", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAuthenticated(t, store, tt.handler, tt.method, tt.path, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body struct {
+				Code      string          `json:"code"`
+				Message   string          `json:"message"`
+				Details   json.RawMessage `json:"details"`
+				RequestID string          `json:"request_id"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body.String(), err)
+			}
+			if body.Code != tt.wantCode || body.Message == "" {
+				t.Errorf("code = %q, message = %q; want code %q with a message", body.Code, body.Message, tt.wantCode)
+			}
+			if got := len(body.Details) > 0; got != tt.wantDetails {
+				t.Errorf("details = %s, want present = %v", body.Details, tt.wantDetails)
+			}
+			if id := rec.Header().Get(middleware.RequestIDHeader); body.RequestID == "" || body.RequestID != id {
+				t.Errorf("request_id = %q, want the X-Request-ID header %q", body.RequestID, id)
+			}
+		})
+	}
+}
@@ -4,31 +4,36 @@ import (
 	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
-	"log"
+	"backend/internal/validation"
+	"log/slog"
 	"net/http"
 
 	"github.com/goccy/go-json"
 )
 
+// 注文履歴一覧で並び替えに使えるフィールド
+const orderSortFields = "oneof=order_id product_name created_at shipped_status arrived_at"
+
 type OrderHandler struct {
+	base
 	OrderSvc *service.OrderService
 }
 
-func NewOrderHandler(svc *service.OrderService) *OrderHandler {
-	return &OrderHandler{OrderSvc: svc}
+func NewOrderHandler(svc *service.OrderService, logger *slog.Logger) *OrderHandler {
+	return &OrderHandler{base: base{logger: logger}, OrderSvc: svc}
 }
 
 // 注文履歴一覧を取得
 func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
-		http.Error(w, "User not found", http.StatusInternalServerError)
+		h.writeError(w, r, errNoUserInContext)
 		return
 	}
 
 	var req model.ListRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(w, r, &req); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -42,28 +47,37 @@ func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
 	if req.SortField == "" {
 		req.SortField = "order_id"
 	}
+	// ベンチマーカーは商品一覧と同じ name で商品名の並び替えを指定してくる
+	if req.SortField == "name" {
+		req.SortField = "product_name"
+	}
+	if err := validation.Var("sort_field", req.SortField, orderSortFields); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
 	if req.SortOrder == "" {
 		req.SortOrder = "desc"
 	}
-	if req.Type != "" && req.Type != "partial" && req.Type != "prefix" {
-		req.Type = "partial"
-	}
 
 	req.Offset = (req.Page - 1) * req.PageSize
 
-	orders, total, err := h.OrderSvc.FetchOrders(r.Context(), userID, req)
+	orders, page, err := h.OrderSvc.FetchOrders(r.Context(), userID, req)
 	if err != nil {
-		log.Printf("Failed to fetch orders for user %d: %v", userID, err)
-		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 
+	// total: "none" では total を返さず、has_more で次のページの有無を伝える
 	resp := struct {
-		Data  []model.Order `json:"data"`
-		Total int           `json:"total"`
+		Data             []model.Order `json:"data"`
+		Total            *int          `json:"total,omitempty"`
+		TotalApproximate bool          `json:"total_approximate,omitempty"`
+		HasMore          bool          `json:"has_more"`
 	}{
-		Data:  orders,
-		Total: total,
+		Data:             orders,
+		Total:            page.Total,
+		TotalApproximate: page.TotalApproximate,
+		HasMore:          page.HasMore,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
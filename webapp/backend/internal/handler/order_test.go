@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"backend/internal/service"
+
+	"github.com/goccy/go-json"
+)
+
+func TestOrderHandlerList(t *testing.T) {
+	store := newSQLiteStore(t)
+	h := NewOrderHandler(service.NewOrderService(store, testTimeouts()), discardLogger)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantIDs    []int64
+	}{
+		// フロントエンドの注文履歴画面が送るリクエスト
+		{"frontend default", `{"search":"","type":"partial","page":1,"page_size":20,"sort_field":"order_id","sort_order":"desc"}`, http.StatusOK, []int64{4, 3, 2, 1}},
+		{"frontend product name", `{"search":"","type":"partial","page":1,"page_size":20,"sort_field":"product_name","sort_order":"asc"}`, http.StatusOK, []int64{1, 4, 2, 3}},
+		{"frontend fulltext", `{"search":"apple","type":"fulltext","page":1,"page_size":20,"sort_field":"order_id","sort_order":"asc"}`, http.StatusOK, []int64{1, 4}},
+		// ベンチマーカーが送るリクエスト
+		{"benchmarker history", `{"search":"","type":"partial","page":1,"page_size":20,"sort_field":"created_at","sort_order":"desc"}`, http.StatusOK, []int64{4, 3, 2, 1}},
+		{"benchmarker name", `{"search":"","type":"partial","page":1,"page_size":20,"sort_field":"name","sort_order":"desc"}`, http.StatusOK, []int64{3, 2, 4, 1}},
+		{"benchmarker shipped status", `{"search":"","type":"partial","page":1,"page_size":20,"sort_field":"shipped_status","sort_order":"asc"}`, http.StatusOK, []int64{4, 3, 1, 2}},
+		{"benchmarker arrived at", `{"search":"","type":"partial","page":1,"page_size":20,"sort_field":"arrived_at","sort_order":"asc"}`, http.StatusOK, []int64{1, 2, 3, 4}},
+		{"benchmarker prefix search", `{"search":"Ch","type":"prefix","page":1,"page_size":20,"sort_field":"created_at","sort_order":"desc"}`, http.StatusOK, []int64{2}},
+		{"defaults", `{}`, http.StatusOK, []int64{4, 3, 2, 1}},
+		{"unknown sort field", `{"sort_field":"user_id"}`, http.StatusBadRequest, nil},
+		{"unknown search type", `{"search":"a","type":"regex"}`, http.StatusBadRequest, nil},
+		{"unknown field", `{"sort":"order_id"}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAuthenticated(t, store, h.List, http.MethodPost, "/api/v1/orders", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Data []struct {
+					OrderID int64 `json:"order_id"`
+				} `json:"data"`
+				Total *int `json:"total"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			var ids []int64
+			for _, o := range resp.Data {
+				ids = append(ids, o.OrderID)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("order ids = %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Fatalf("order ids = %v, want %v", ids, tt.wantIDs)
+				}
+			}
+			if resp.Total == nil || *resp.Total != len(tt.wantIDs) {
+				t.Errorf("total = %v, want %d", resp.Total, len(tt.wantIDs))
+			}
+		})
+	}
+}
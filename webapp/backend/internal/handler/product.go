@@ -1,11 +1,16 @@
 package handler
 
 import (
+	"backend/internal/apperror"
+	"backend/internal/imagestore"
 	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+	"backend/internal/validation"
+	"bytes"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -14,25 +19,31 @@ import (
 	"github.com/goccy/go-json"
 )
 
+// 商品一覧で並び替えに使えるフィールド
+const productSortFields = "oneof=product_id name value weight"
+
 type ProductHandler struct {
+	base
 	ProductSvc *service.ProductService
+	Images     *imagestore.Store
+	ImageCache *imagestore.Cache
 }
 
-func NewProductHandler(svc *service.ProductService) *ProductHandler {
-	return &ProductHandler{ProductSvc: svc}
+func NewProductHandler(svc *service.ProductService, images *imagestore.Store, imageCache *imagestore.Cache, logger *slog.Logger) *ProductHandler {
+	return &ProductHandler{base: base{logger: logger}, ProductSvc: svc, Images: images, ImageCache: imageCache}
 }
 
 // 商品一覧を取得
 func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
-		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		h.writeError(w, r, errNoUserInContext)
 		return
 	}
 
 	var req model.ListRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(w, r, &req); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -45,6 +56,11 @@ func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 	if req.SortField == "" {
 		req.SortField = "product_id"
 	}
+	// ORDER BY 句に埋め込まれるため許可したフィールド以外は受け付けない
+	if err := validation.Var("sort_field", req.SortField, productSortFields); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
 	if req.SortOrder == "" {
 		req.SortOrder = "asc"
 	}
@@ -52,8 +68,7 @@ func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 
 	products, total, err := h.ProductSvc.FetchProducts(r.Context(), userID, req)
 	if err != nil {
-		log.Printf("Failed to fetch products for user %d: %v", userID, err)
-		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -73,20 +88,19 @@ func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
-		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		h.writeError(w, r, errNoUserInContext)
 		return
 	}
 
 	var req model.CreateOrderRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(w, r, &req); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
 	insertedOrderIDs, err := h.ProductSvc.CreateOrders(r.Context(), userID, req.Items)
 	if err != nil {
-		log.Printf("Failed to create orders: %v", err)
-		http.Error(w, "Failed to process order request", http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -100,27 +114,16 @@ func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("画像リクエスト受信: %s\n", r.URL.String())
 	imagePath := r.URL.Query().Get("path")
 	if imagePath == "" {
-		fmt.Println("画像パスが空です")
-		http.Error(w, "画像パスが指定されていません", http.StatusBadRequest)
+		h.writeError(w, r, apperror.New(apperror.CodeBadRequest, "Query parameter 'path' is required"))
 		return
 	}
 
-	imagePath = filepath.Clean(imagePath)
-	if filepath.IsAbs(imagePath) || strings.Contains(imagePath, "..") {
-		fmt.Printf("無効なパス: %s\n", imagePath)
-		http.Error(w, "無効なパスです", http.StatusBadRequest)
-		return
-	}
-
-	baseImageDir := "/app/images"
-	fullPath := filepath.Join(baseImageDir, imagePath)
-
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		fmt.Printf("画像ファイルが見つかりません: %s\n", fullPath)
-		http.Error(w, "画像が見つかりません", http.StatusNotFound)
+	// size: original(既定) / small / medium / large
+	fullPath, err := h.Images.Open(imagePath, r.URL.Query().Get("size"))
+	if err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -140,12 +143,40 @@ func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", contentType)
 
-	data, err := os.ReadFile(fullPath)
+	f, err := os.Open(fullPath)
 	if err != nil {
-		fmt.Printf("画像ファイルの読み込みに失敗: %s\n", fullPath)
-		http.Error(w, "画像の読み込みに失敗しました", http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	if h.Images.Immutable(imagePath) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
 
-	w.Write(data)
+	// If-None-Match / If-Modified-Since / Range は http.ServeContent に任せる
+	if data, ok := h.ImageCache.Get(fullPath, info.ModTime(), info.Size()); ok {
+		h.logger.DebugContext(r.Context(), "image served from cache", "path", fullPath)
+		http.ServeContent(w, r, fullPath, info.ModTime(), bytes.NewReader(data))
+		return
+	}
+	if h.ImageCache.Cacheable(info.Size()) {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+		h.ImageCache.Add(fullPath, data, info.ModTime())
+		http.ServeContent(w, r, fullPath, info.ModTime(), bytes.NewReader(data))
+		return
+	}
+	http.ServeContent(w, r, fullPath, info.ModTime(), f)
 }
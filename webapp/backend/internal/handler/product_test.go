@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"backend/internal/metrics"
+	"backend/internal/service"
+)
+
+func TestProductHandlerList(t *testing.T) {
+	store := newSQLiteStore(t)
+	svc := service.NewProductService(store, testTimeouts(), discardLogger, metrics.New())
+	h := NewProductHandler(svc, nil, nil, discardLogger)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		// フロントエンドの商品一覧画面が送るリクエスト
+		{"frontend default", `{"search":"","page":1,"page_size":20,"sort_field":"product_id","sort_order":"asc"}`, http.StatusOK},
+		{"frontend sort by weight", `{"search":"apple","page":2,"page_size":50,"sort_field":"weight","sort_order":"desc"}`, http.StatusOK},
+		// ベンチマーカーが送るリクエスト
+		{"benchmarker list", `{"search":"","type":"partial","page":1,"page_size":20,"sort_field":"name","sort_order":"asc"}`, http.StatusOK},
+		{"benchmarker sort by value", `{"search":"","type":"partial","page":3,"page_size":20,"sort_field":"value","sort_order":"desc"}`, http.StatusOK},
+		{"unknown sort field", `{"sort_field":"description"}`, http.StatusBadRequest},
+		{"page size too large", `{"page_size":1000}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAuthenticated(t, store, h.List, http.MethodPost, "/api/v1/product", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
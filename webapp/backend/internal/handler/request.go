@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"backend/internal/apperror"
+	"backend/internal/validation"
+
+	"github.com/goccy/go-json"
+)
+
+// JSON リクエストボディの最大サイズ
+const maxJSONBodySize = 1 << 20
+
+// リクエストボディを厳密にデコードし、validate タグで検証する
+// 未知のフィールドや JSON の後ろに続くデータはエラーにする
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		if err == nil {
+			err = errors.New("unexpected data after JSON body")
+		}
+		return decodeError(err)
+	}
+	return validation.Struct(dst)
+}
+
+func decodeError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return apperror.Wrap(apperror.CodeRequestTooLarge, "Request body is too large", err)
+	}
+	// 未知のフィールド名や型の不一致をクライアントが特定できるよう原因を載せる
+	return invalidBody(err).WithDetails(map[string]string{"reason": err.Error()})
+}
@@ -1,9 +1,10 @@
 package handler
 
 import (
+	"backend/internal/apperror"
 	"backend/internal/model"
 	"backend/internal/service"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -11,11 +12,12 @@ import (
 )
 
 type RobotHandler struct {
+	base
 	RobotSvc *service.RobotService
 }
 
-func NewRobotHandler(robotSvc *service.RobotService) *RobotHandler {
-	return &RobotHandler{RobotSvc: robotSvc}
+func NewRobotHandler(robotSvc *service.RobotService, logger *slog.Logger) *RobotHandler {
+	return &RobotHandler{base: base{logger: logger}, RobotSvc: robotSvc}
 }
 
 // 配送計画を取得
@@ -24,19 +26,18 @@ func (h *RobotHandler) GetDeliveryPlan(w http.ResponseWriter, r *http.Request) {
 
 	capacityStr := r.URL.Query().Get("capacity")
 	if capacityStr == "" {
-		http.Error(w, "Query parameter 'capacity' is required", http.StatusBadRequest)
+		h.writeError(w, r, apperror.New(apperror.CodeBadRequest, "Query parameter 'capacity' is required"))
 		return
 	}
 	capacity, err := strconv.Atoi(capacityStr)
 	if err != nil {
-		http.Error(w, "Query parameter 'capacity' must be an integer", http.StatusBadRequest)
+		h.writeError(w, r, apperror.New(apperror.CodeBadRequest, "Query parameter 'capacity' must be an integer"))
 		return
 	}
 
 	plan, err := h.RobotSvc.GenerateDeliveryPlan(r.Context(), robotID, capacity)
 	if err != nil {
-		log.Printf("Failed to generate delivery plan: %v", err)
-		http.Error(w, "Failed to create delivery plan", http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 
@@ -47,18 +48,18 @@ func (h *RobotHandler) GetDeliveryPlan(w http.ResponseWriter, r *http.Request) {
 // 配送完了時に注文ステータスを更新
 func (h *RobotHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 	var req model.UpdateOrderStatusRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(w, r, &req); err != nil {
+		h.writeError(w, r, err)
 		return
 	}
 
 	err := h.RobotSvc.UpdateOrderStatus(r.Context(), req.OrderID, req.NewStatus)
 	if err != nil {
-		log.Printf("Failed to update order status for order %d: %v", req.OrderID, err)
-		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
+		h.writeError(w, r, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Order status updated"))
 }
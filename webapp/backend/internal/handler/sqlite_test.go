@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"backend/internal/config"
+	"backend/internal/middleware"
+	"backend/internal/repository"
+	"backend/internal/repository/sqlitetest"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// リポジトリのテストと同じスキーマとフィクスチャを読み込んだ SQLite の Store を作る
+func newSQLiteStore(t *testing.T) *repository.Store {
+	t.Helper()
+	store := repository.NewStore(sqlitetest.Open(t), nil, discardLogger, repository.WithDialect(repository.SQLite))
+	t.Cleanup(store.Close)
+	return store
+}
+
+// 本番と同じくリクエストIDとセッション認証を通してハンドラーを呼ぶ
+func serveAuthenticated(t *testing.T, store *repository.Store, h http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := middleware.RequestID(middleware.UserAuthMiddleware(store.SessionRepo, discardLogger)(h))
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: sqlitetest.SessionID})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func testTimeouts() config.TimeoutsConfig {
+	return config.Default().Timeouts
+}
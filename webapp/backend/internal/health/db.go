@@ -0,0 +1,97 @@
+package health
+
+import (
+	"backend/internal/migrate"
+	"backend/internal/repository"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DB に ping が通るか
+func DBPing(db *sqlx.DB) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		return nil, db.PingContext(ctx)
+	}
+}
+
+type poolDetails struct {
+	MaxOpen   int   `json:"max_open"`
+	Open      int   `json:"open"`
+	InUse     int   `json:"in_use"`
+	Idle      int   `json:"idle"`
+	WaitCount int64 `json:"wait_count"`
+}
+
+// コネクションプールが飽和していないか
+// 全接続が使用中で、前回のチェック以降に接続待ちが発生していれば失敗とする
+func DBPool(db *sqlx.DB) CheckFunc {
+	var mu sync.Mutex
+	var lastWaitCount int64
+	return func(ctx context.Context) (any, error) {
+		stats := db.Stats()
+		details := poolDetails{
+			MaxOpen:   stats.MaxOpenConnections,
+			Open:      stats.OpenConnections,
+			InUse:     stats.InUse,
+			Idle:      stats.Idle,
+			WaitCount: stats.WaitCount,
+		}
+
+		mu.Lock()
+		waited := stats.WaitCount > lastWaitCount
+		lastWaitCount = stats.WaitCount
+		mu.Unlock()
+
+		if stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections && waited {
+			return details, errors.New("connection pool saturated")
+		}
+		return details, nil
+	}
+}
+
+type replicaDetails struct {
+	Healthy bool `json:"healthy"`
+}
+
+// 読み取り用レプリカの状態
+// 不調の間はプライマリで読めるため、レディネスは失敗させずに詳細だけ返す
+func Replica(replica *repository.Replica) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		return replicaDetails{Healthy: replica.Healthy()}, nil
+	}
+}
+
+type migrationDetails struct {
+	Latest  int64    `json:"latest"`
+	Pending []string `json:"pending,omitempty"`
+	Failed  []string `json:"failed,omitempty"`
+}
+
+// 埋め込んだマイグレーションが全て適用済みか
+// 未適用のもの、途中で失敗したもの、適用後に書き換えられたものがあれば失敗とする
+func Migration(m *migrate.Migrator) CheckFunc {
+	return func(ctx context.Context) (any, error) {
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+		details := migrationDetails{Latest: m.Latest()}
+		for _, st := range statuses {
+			name := fmt.Sprintf("%d_%s", st.Version, st.Name)
+			switch st.State {
+			case migrate.StatePending:
+				details.Pending = append(details.Pending, name)
+			case migrate.StateDirty, migrate.StateModified:
+				details.Failed = append(details.Failed, name+" ("+st.State+")")
+			}
+		}
+		if len(details.Pending) > 0 || len(details.Failed) > 0 {
+			return details, errors.New("schema is not up to date; run \"migrate up\"")
+		}
+		return details, nil
+	}
+}
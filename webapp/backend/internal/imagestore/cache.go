@@ -0,0 +1,86 @@
+package imagestore
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// よく参照される小さな画像をメモリに載せておく LRU キャッシュ
+// ファイルの更新時刻とサイズが一致する場合だけヒット扱いにする
+type Cache struct {
+	mu           sync.Mutex
+	ll           *list.List
+	items        map[string]*list.Element
+	maxBytes     int64
+	maxItemBytes int64
+	curBytes     int64
+}
+
+type cacheEntry struct {
+	key     string
+	data    []byte
+	modTime time.Time
+}
+
+// maxBytes: キャッシュ全体の上限, maxItemBytes: 1ファイルあたりの上限
+func NewCache(maxBytes, maxItemBytes int64) *Cache {
+	return &Cache{
+		ll:           list.New(),
+		items:        make(map[string]*list.Element),
+		maxBytes:     maxBytes,
+		maxItemBytes: maxItemBytes,
+	}
+}
+
+// キャッシュ対象にできるサイズか
+func (c *Cache) Cacheable(size int64) bool {
+	return size <= c.maxItemBytes && size <= c.maxBytes
+}
+
+func (c *Cache) Get(key string, modTime time.Time, size int64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !entry.modTime.Equal(modTime) || int64(len(entry.data)) != size {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.data, true
+}
+
+func (c *Cache) Add(key string, data []byte, modTime time.Time) {
+	if !c.Cacheable(int64(len(data))) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	el := c.ll.PushFront(&cacheEntry{key: key, data: data, modTime: modTime})
+	c.items[key] = el
+	c.curBytes += int64(len(data))
+
+	for c.curBytes > c.maxBytes {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.remove(oldest)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	entry := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.curBytes -= int64(len(entry.data))
+}
@@ -0,0 +1,245 @@
+// 商品画像の保存とサムネイル生成を担う
+package imagestore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrInvalidPath      = errors.New("invalid image path")
+	ErrInvalidSize      = errors.New("invalid image size")
+	ErrUnsupportedImage = errors.New("unsupported image type")
+	ErrNotFound         = errors.New("image not found")
+	ErrTooLarge         = errors.New("image dimensions too large")
+)
+
+// デコードを許す画素数の上限。小さなファイルで巨大な画像を宣言してメモリを使い切らせる攻撃を防ぐ
+const maxPixels = 40_000_000
+
+// オリジナルを表すサイズ名
+const SizeOriginal = "original"
+
+// サムネイルのサイズ名と長辺のピクセル数
+var Sizes = map[string]int{
+	"small":  150,
+	"medium": 300,
+	"large":  600,
+}
+
+// 受け付ける画像形式と保存時の拡張子
+var allowedTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// サムネイルは thumbs/<size>/ 以下に置く
+const thumbDir = "thumbs"
+
+// Save が付ける UUID 入りのファイル名
+var savedNamePattern = regexp.MustCompile(`_[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.[a-z]+$`)
+
+type Store struct {
+	dir    string
+	logger *slog.Logger
+	// 同じサムネイルを同時に要求されても 1 回だけ生成する。キーは出力先のパス
+	thumbs singleflight.Group
+}
+
+func New(dir string, logger *slog.Logger) *Store {
+	return &Store{dir: dir, logger: logger}
+}
+
+// 画像を検証して保存し、全サイズのサムネイルを生成する
+// 戻り値は Open に渡す相対パス
+func (s *Store) Save(prefix string, data []byte) (string, error) {
+	ext, ok := allowedTypes[http.DetectContentType(data)]
+	if !ok {
+		return "", ErrUnsupportedImage
+	}
+	// 先頭バイトだけ画像に見せかけたファイルを弾くため、実際にデコードする
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s_%s%s", prefix, uuid.NewString(), ext)
+	if err := os.WriteFile(filepath.Join(s.dir, name), data, 0o644); err != nil {
+		return "", err
+	}
+
+	for size := range Sizes {
+		if _, err := s.writeThumbnail(img, name, size); err != nil {
+			// サムネイルは Open 時にも生成できるので保存自体は成功とする
+			s.logger.Warn("thumbnail generation failed", "size", size, "image", name, "error", err)
+		}
+	}
+	return name, nil
+}
+
+// 指定サイズの画像ファイルのパスを返す
+// サムネイルがまだ無い画像(初期データなど)はその場で生成する
+func (s *Store) Open(imagePath, size string) (string, error) {
+	imagePath = filepath.Clean(imagePath)
+	if filepath.IsAbs(imagePath) || strings.Contains(imagePath, "..") {
+		return "", ErrInvalidPath
+	}
+	if size == "" {
+		size = SizeOriginal
+	}
+	if _, ok := Sizes[size]; !ok && size != SizeOriginal {
+		return "", ErrInvalidSize
+	}
+
+	original := filepath.Join(s.dir, imagePath)
+	if _, err := os.Stat(original); err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	if size == SizeOriginal {
+		return original, nil
+	}
+
+	thumb := s.thumbnailPath(imagePath, size)
+	if _, err := os.Stat(thumb); err == nil {
+		return thumb, nil
+	}
+
+	path, err, _ := s.thumbs.Do(thumb, func() (any, error) {
+		// 直前の生成が終わったところかもしれない
+		if _, err := os.Stat(thumb); err == nil {
+			return thumb, nil
+		}
+		f, err := os.Open(original)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		img, err := decode(f)
+		if err != nil {
+			return "", err
+		}
+		return s.writeThumbnail(img, imagePath, size)
+	})
+	if err != nil {
+		return "", err
+	}
+	return path.(string), nil
+}
+
+// Save で保存した画像は同じ名前で中身が変わらないため、長期間キャッシュさせてよい
+func (s *Store) Immutable(imagePath string) bool {
+	return savedNamePattern.MatchString(filepath.Base(imagePath))
+}
+
+// 透過を保てるよう PNG/GIF は PNG、それ以外は JPEG で保存する
+func (s *Store) thumbnailPath(imagePath, size string) string {
+	ext := strings.ToLower(filepath.Ext(imagePath))
+	base := strings.TrimSuffix(imagePath, filepath.Ext(imagePath))
+	if ext == ".png" || ext == ".gif" {
+		return filepath.Join(s.dir, thumbDir, size, base+".png")
+	}
+	return filepath.Join(s.dir, thumbDir, size, base+".jpg")
+}
+
+func (s *Store) writeThumbnail(img image.Image, imagePath, size string) (string, error) {
+	thumb := resize(img, Sizes[size])
+	path := s.thumbnailPath(imagePath, size)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if filepath.Ext(path) == ".png" {
+		err = png.Encode(&buf, thumb)
+	} else {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// 並行リクエストが書きかけのファイルを読まないよう、一時ファイルから rename する
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".thumb-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// 画素数を確かめてからデコードする
+func decode(r io.ReadSeeker) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("%w: empty image", ErrUnsupportedImage)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	return img, nil
+}
+
+// 縦横比を保ったまま長辺を maxSide に縮小する。元画像の方が小さい場合は拡大しない
+func resize(img image.Image, maxSide int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxSide && h <= maxSide {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*maxSide/w)
+		w = maxSide
+	} else {
+		w = max(1, w*maxSide/h)
+		h = maxSide
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,109 @@
+package imagestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	return New(t.TempDir(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// ヘッダーだけ 65535x65535 と宣言した小さな GIF
+func gifBomb(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black, color.White}), nil); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint16(data[6:], 0xffff)
+	binary.LittleEndian.PutUint16(data[8:], 0xffff)
+	return data
+}
+
+func TestSaveRejectsTooManyPixels(t *testing.T) {
+	s := newTestStore(t)
+	if _, err := s.Save("product_1", gifBomb(t)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Save error = %v, want %v", err, ErrTooLarge)
+	}
+	if _, err := s.Save("product_1", encodePNG(t, 20, 10)); err != nil {
+		t.Errorf("Save: %v", err)
+	}
+}
+
+func TestOpenRejectsTooManyPixels(t *testing.T) {
+	s := newTestStore(t)
+	if err := os.WriteFile(filepath.Join(s.dir, "bomb.gif"), gifBomb(t), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := s.Open("bomb.gif", "small"); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Open error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestOpenGeneratesThumbnailOnce(t *testing.T) {
+	s := newTestStore(t)
+	if err := os.WriteFile(filepath.Join(s.dir, "apple.png"), encodePNG(t, 800, 400), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	paths := make([]string, 8)
+	errs := make([]error, 8)
+	for i := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			paths[i], errs[i] = s.Open("apple.png", "medium")
+		}()
+	}
+	wg.Wait()
+	for i := range paths {
+		if errs[i] != nil || paths[i] != s.thumbnailPath("apple.png", "medium") {
+			t.Fatalf("Open = %q, %v", paths[i], errs[i])
+		}
+	}
+
+	f, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatalf("open thumbnail: %v", err)
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("decode thumbnail: %v", err)
+	}
+	if cfg.Width != 300 || cfg.Height != 150 {
+		t.Errorf("thumbnail = %dx%d, want 300x150", cfg.Width, cfg.Height)
+	}
+	// 一時ファイルを残さない
+	entries, err := os.ReadDir(filepath.Dir(paths[0]))
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("thumbnail dir has %d entries, want 1", len(entries))
+	}
+}
@@ -0,0 +1,95 @@
+// slog による構造化ログ
+// コンテキストに載っているリクエストIDとトレースIDを各ログに付与し、機密情報を伏せて出力する
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"backend/internal/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ロガーを作る。format は json(既定) または text
+// extra には w 以外の出力先(OpenTelemetry へのブリッジなど)を渡す。nil は無視する
+func New(w io.Writer, cfg config.LogConfig, extra ...slog.Handler) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format: %q", cfg.Format)
+	}
+
+	handlers := []slog.Handler{h}
+	for _, e := range extra {
+		if e != nil {
+			handlers = append(handlers, &levelHandler{Handler: e, level: level})
+		}
+	}
+	if len(handlers) > 1 {
+		h = fanoutHandler(handlers)
+	}
+	return slog.New(&contextHandler{Handler: &redactHandler{Handler: h}}), nil
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level: %q", s)
+	}
+	return level, nil
+}
+
+// コンテキストからリクエストIDとトレースIDを取り出してログに付与する
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// リクエストIDを返す。RequestID ミドルウェアを通っていなければ空文字
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
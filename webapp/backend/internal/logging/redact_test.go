@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"backend/internal/config"
+
+	"github.com/goccy/go-json"
+)
+
+// リクエストヘッダーの認証情報は属性、グループ、With のどこに渡しても伏せる
+func TestRedactHeaders(t *testing.T) {
+	const (
+		authorization = "Bearer secret-bearer"
+		cookie        = "session_id=secret-session"
+		xsrf          = "secret-xsrf"
+	)
+	var buf bytes.Buffer
+	logger, err := New(&buf, config.LogConfig{Format: "json"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	logger.With(slog.String("X-XSRF-TOKEN", xsrf)).Info("request",
+		slog.String("Authorization", authorization),
+		slog.String("Cookie", cookie),
+		slog.Group("headers",
+			slog.String("authorization", authorization),
+			slog.String("cookie", cookie),
+			slog.String("x-xsrf-token", xsrf),
+			slog.String("accept", "application/json"),
+		),
+	)
+
+	for _, secret := range []string{"secret-bearer", "secret-session", xsrf} {
+		if strings.Contains(buf.String(), secret) {
+			t.Errorf("log contains %q: %s", secret, buf.String())
+		}
+	}
+	var entry struct {
+		Authorization string            `json:"Authorization"`
+		Cookie        string            `json:"Cookie"`
+		XSRFToken     string            `json:"X-XSRF-TOKEN"`
+		Headers       map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	for key, got := range map[string]string{
+		"Authorization":         entry.Authorization,
+		"Cookie":                entry.Cookie,
+		"X-XSRF-TOKEN":          entry.XSRFToken,
+		"headers.authorization": entry.Headers["authorization"],
+		"headers.cookie":        entry.Headers["cookie"],
+		"headers.x-xsrf-token":  entry.Headers["x-xsrf-token"],
+	} {
+		if got != redacted {
+			t.Errorf("%s = %q, want %q", key, got, redacted)
+		}
+	}
+	if got := entry.Headers["accept"]; got != "application/json" {
+		t.Errorf("headers.accept = %q, want it left as is", got)
+	}
+}
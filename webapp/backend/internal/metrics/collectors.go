@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"backend/internal/repository"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SessionRepository のキャッシュ統計をスクレイプ時に読み出す
+// ヒット率は rate(hits) / (rate(hits) + rate(misses)) で求める
+type sessionCacheCollector struct {
+	repo    *repository.SessionRepository
+	hits    *prometheus.Desc
+	misses  *prometheus.Desc
+	entries *prometheus.Desc
+}
+
+func NewSessionCacheCollector(repo *repository.SessionRepository) prometheus.Collector {
+	return &sessionCacheCollector{
+		repo: repo,
+		hits: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "session_cache", "hits_total"),
+			"Number of session lookups served from the in-memory cache.", nil, nil),
+		misses: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "session_cache", "misses_total"),
+			"Number of session lookups that went to the database.", nil, nil),
+		entries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "session_cache", "entries"),
+			"Number of sessions currently cached.", nil, nil),
+	}
+}
+
+func (c *sessionCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.entries
+}
+
+func (c *sessionCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.repo.CacheStats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(stats.Entries))
+}
+
+// ProductRepository の一覧キャッシュの統計をスクレイプ時に読み出す
+type productCatalogCollector struct {
+	repo          *repository.ProductRepository
+	hits          *prometheus.Desc
+	misses        *prometheus.Desc
+	evictions     *prometheus.Desc
+	invalidations *prometheus.Desc
+	entries       *prometheus.Desc
+}
+
+func NewProductCatalogCollector(repo *repository.ProductRepository) prometheus.Collector {
+	return &productCatalogCollector{
+		repo: repo,
+		hits: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "product_catalog_cache", "hits_total"),
+			"Number of product listings served from the in-memory cache.", nil, nil),
+		misses: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "product_catalog_cache", "misses_total"),
+			"Number of product listings that went to the database.", nil, nil),
+		evictions: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "product_catalog_cache", "evictions_total"),
+			"Number of cached search results evicted by the LRU limit.", nil, nil),
+		invalidations: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "product_catalog_cache", "invalidations_total"),
+			"Number of times the cache was cleared by product writes.", nil, nil),
+		entries: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "product_catalog_cache", "entries"),
+			"Number of product listings currently cached.", []string{"kind"}, nil),
+	}
+}
+
+func (c *productCatalogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.invalidations
+	ch <- c.entries
+}
+
+func (c *productCatalogCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.repo.CatalogStats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.invalidations, prometheus.CounterValue, float64(stats.Invalidations))
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(stats.Listings), "listing")
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(stats.Searches), "search")
+}
@@ -0,0 +1,109 @@
+// Prometheus 形式のメトリクス
+// 指標は New で作った Metrics にまとめ、必要な箇所へコンストラクタで渡す
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "backend"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpDuration         *prometheus.HistogramVec
+	deliveryPlanDuration prometheus.Histogram
+	deliveryPlanOrders   prometheus.Histogram
+	ordersCreated        prometheus.Counter
+	shippingCacheDrift   *prometheus.GaugeVec
+	shippingCacheRepairs prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by chi route pattern.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"method", "route", "status"}),
+		deliveryPlanDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "delivery_plan_solve_duration_seconds",
+			Help:      "Time spent selecting orders for a delivery plan.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}),
+		deliveryPlanOrders: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "delivery_plan_selected_orders",
+			Help:      "Number of orders selected per delivery plan.",
+			Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500},
+		}),
+		ordersCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "orders_created_total",
+			Help:      "Number of orders created. Use rate() for orders per minute.",
+		}),
+		shippingCacheDrift: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "shipping_cache_drift_orders",
+			Help:      "Orders whose shipping_order_cache row was missing, stale or orphaned at the last check.",
+		}, []string{"kind"}),
+		shippingCacheRepairs: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "shipping_cache_repaired_rows_total",
+			Help:      "Number of shipping_order_cache rows inserted, updated or deleted by repairs.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpDuration,
+		m.deliveryPlanDuration,
+		m.deliveryPlanOrders,
+		m.ordersCreated,
+		m.shippingCacheDrift,
+		m.shippingCacheRepairs,
+	)
+	return m
+}
+
+// 追加のコレクターを登録する
+func (m *Metrics) MustRegister(cs ...prometheus.Collector) {
+	m.registry.MustRegister(cs...)
+}
+
+// /metrics 用のハンドラー
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+func (m *Metrics) ObserveHTTPRequest(method, route string, status int, d time.Duration) {
+	m.httpDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+func (m *Metrics) ObserveDeliveryPlan(d time.Duration, selectedOrders int) {
+	m.deliveryPlanDuration.Observe(d.Seconds())
+	m.deliveryPlanOrders.Observe(float64(selectedOrders))
+}
+
+func (m *Metrics) AddOrdersCreated(n int) {
+	m.ordersCreated.Add(float64(n))
+}
+
+func (m *Metrics) SetShippingCacheDrift(missing, stale, orphaned int) {
+	m.shippingCacheDrift.WithLabelValues("missing").Set(float64(missing))
+	m.shippingCacheDrift.WithLabelValues("stale").Set(float64(stale))
+	m.shippingCacheDrift.WithLabelValues("orphaned").Set(float64(orphaned))
+}
+
+func (m *Metrics) AddShippingCacheRepairs(n int64) {
+	m.shippingCacheRepairs.Add(float64(n))
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"backend/internal/logging"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// リクエストごとにステータスと処理時間をログに出す
+// トレースIDをログに載せるため otelchi の後ろに置くこと
+// 成功したリクエストは debug、slow 以上かかったものは info、4xx は warn、5xx は error で出す
+// skip に含まれるパス(ヘルスチェックやメトリクスなど)は失敗したときだけ出す
+func AccessLog(logger *slog.Logger, slow time.Duration, skip ...string) func(http.Handler) http.Handler {
+	skipPaths := make(map[string]struct{}, len(skip))
+	for _, p := range skip {
+		skipPaths[p] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if id := logging.RequestID(ctx); id != "" {
+				trace.SpanFromContext(ctx).SetAttributes(attribute.String("http.request_id", id))
+			}
+
+			start := time.Now()
+			ww := chimw.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			elapsed := time.Since(start)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelDebug
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			default:
+				if _, ok := skipPaths[r.URL.Path]; ok {
+					return
+				}
+				if slow > 0 && elapsed >= slow {
+					level = slog.LevelInfo
+				}
+			}
+			// 出力しないレベルなら属性を組み立てない
+			if !logger.Enabled(ctx, level) {
+				return
+			}
+
+			route := ""
+			if rctx := chi.RouteContext(ctx); rctx != nil {
+				route = rctx.RoutePattern()
+			}
+			logger.LogAttrs(ctx, level, "request completed",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", route),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("user_agent", r.UserAgent()),
+			)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestAccessLogLevel(t *testing.T) {
+	const slow = 50 * time.Millisecond
+	tests := []struct {
+		name      string
+		path      string
+		status    int
+		delay     time.Duration
+		wantLevel string
+	}{
+		{"success", "/api/v1/product/list", http.StatusOK, 0, "DEBUG"},
+		{"slow success", "/api/v1/product/list", http.StatusOK, slow, "INFO"},
+		{"client error", "/api/v1/product/list", http.StatusNotFound, 0, "WARN"},
+		{"server error", "/api/v1/product/list", http.StatusInternalServerError, 0, "ERROR"},
+		{"probe", "/livez", http.StatusOK, 0, ""},
+		{"slow probe", "/readyz", http.StatusOK, slow, ""},
+		{"failing probe", "/readyz", http.StatusServiceUnavailable, 0, "ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			h := AccessLog(logger, slow, "/livez", "/readyz")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+				w.WriteHeader(tt.status)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if tt.wantLevel == "" {
+				if buf.Len() != 0 {
+					t.Errorf("logged %s, want nothing", buf.String())
+				}
+				return
+			}
+			var entry struct {
+				Level  string `json:"level"`
+				Path   string `json:"path"`
+				Status int    `json:"status"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("decode %q: %v", buf.String(), err)
+			}
+			if entry.Level != tt.wantLevel || entry.Path != tt.path || entry.Status != tt.status {
+				t.Errorf("entry = %+v, want level %s path %s status %d", entry, tt.wantLevel, tt.path, tt.status)
+			}
+		})
+	}
+}
+
+// 出力しないレベルのリクエストは何も書かない
+func TestAccessLogDisabledLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	h := AccessLog(logger, time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/product/list", nil))
+	if buf.Len() != 0 {
+		t.Errorf("logged %s at info level, want nothing", buf.String())
+	}
+}
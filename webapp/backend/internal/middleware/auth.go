@@ -2,9 +2,12 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 
+	"backend/internal/apperror"
+	"backend/internal/model"
 	"backend/internal/repository"
 )
 
@@ -12,21 +15,26 @@ type contextKey string
 
 const userContextKey contextKey = "user"
 
-func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Handler) http.Handler {
+// Authorization: Bearer ヘッダーまたは session_id Cookie でユーザーを認証する
+// 両方ある場合は Bearer を優先する
+func UserAuthMiddleware(sessionRepo *repository.SessionRepository, logger *slog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("session_id")
-			if err != nil {
-				log.Printf("Error retrieving session cookie: %v", err)
-				http.Error(w, "Unauthorized: No session cookie", http.StatusUnauthorized)
-				return
+			sessionID, ok := BearerToken(r)
+			if !ok {
+				cookie, err := r.Cookie("session_id")
+				if err != nil {
+					logger.DebugContext(r.Context(), "no session credentials", "error", err)
+					apperror.Write(w, r, apperror.New(apperror.CodeUnauthorized, "No session cookie or bearer token"))
+					return
+				}
+				sessionID = cookie.Value
 			}
-			sessionID := cookie.Value
 
 			userID, err := sessionRepo.FindUserBySessionID(r.Context(), sessionID)
 			if err != nil {
-				log.Printf("Error finding user by session ID: %v", err)
-				http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
+				logger.DebugContext(r.Context(), "session lookup failed", "error", err)
+				apperror.Write(w, r, apperror.New(apperror.CodeUnauthorized, "Invalid session"))
 				return
 			}
 
@@ -36,13 +44,49 @@ func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Han
 	}
 }
 
+// Authorization ヘッダーから Bearer トークンを取り出す
+func BearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	scheme, token, found := strings.Cut(auth, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// 管理者ロールのユーザーだけを通す
+// UserAuthMiddleware の後ろに置くこと
+func AdminOnlyMiddleware(userRepo *repository.UserRepository, logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := GetUserFromContext(r.Context())
+			if !ok {
+				apperror.Write(w, r, apperror.New(apperror.CodeUnauthorized, "Authentication required"))
+				return
+			}
+			role, err := userRepo.FindRoleByID(r.Context(), userID)
+			if err != nil {
+				logger.WarnContext(r.Context(), "role lookup failed", "user_id", userID, "error", err)
+				apperror.Write(w, r, apperror.New(apperror.CodeForbidden, "Admin role required"))
+				return
+			}
+			if role != model.RoleAdmin {
+				apperror.Write(w, r, apperror.New(apperror.CodeForbidden, "Admin role required"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func RobotAuthMiddleware(validAPIKey string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			apiKey := r.Header.Get("X-API-KEY")
 
 			if apiKey == "" || apiKey != validAPIKey {
-				http.Error(w, "Forbidden: Invalid or missing API key", http.StatusForbidden)
+				apperror.Write(w, r, apperror.New(apperror.CodeForbidden, "Invalid or missing API key"))
 				return
 			}
 			next.ServeHTTP(w, r)
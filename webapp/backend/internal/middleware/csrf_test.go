@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	const token = "0123456789abcdef"
+	tests := []struct {
+		name          string
+		method        string
+		cookie        string
+		header        string
+		authorization string
+		wantStatus    int
+		wantMessage   string
+	}{
+		{"matching token", http.MethodPost, token, token, "", http.StatusOK, ""},
+		{"missing header", http.MethodPost, token, "", "", http.StatusForbidden, "Invalid CSRF token"},
+		{"header does not match cookie", http.MethodPost, token, "fedcba9876543210", "", http.StatusForbidden, "Invalid CSRF token"},
+		{"missing cookie", http.MethodPut, "", token, "", http.StatusForbidden, "Missing CSRF token"},
+		{"bearer token", http.MethodDelete, "", "", "Bearer abc", http.StatusOK, ""},
+		{"bearer token with mismatched csrf", http.MethodPatch, token, "other", "bearer abc", http.StatusOK, ""},
+		{"empty bearer token", http.MethodPost, "", "", "Bearer ", http.StatusForbidden, "Missing CSRF token"},
+		{"basic auth is not exempt", http.MethodPost, "", "", "Basic YTpi", http.StatusForbidden, "Missing CSRF token"},
+		{"get", http.MethodGet, "", "", "", http.StatusOK, ""},
+		{"head", http.MethodHead, "", "", "", http.StatusOK, ""},
+		{"options", http.MethodOptions, "", "", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := CSRFMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			req := httptest.NewRequest(tt.method, "/api/v1/product/post", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeaderName, tt.header)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("next called = %v", called)
+			}
+			if tt.wantMessage == "" {
+				return
+			}
+			var body struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", rec.Body.String(), err)
+			}
+			if body.Code != "forbidden" || body.Message != tt.wantMessage {
+				t.Errorf("body = %+v, want forbidden %q", body, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestNewCSRFToken(t *testing.T) {
+	a, err := NewCSRFToken()
+	if err != nil {
+		t.Fatalf("NewCSRFToken: %v", err)
+	}
+	b, err := NewCSRFToken()
+	if err != nil {
+		t.Fatalf("NewCSRFToken: %v", err)
+	}
+	if len(a) != 64 || a == b {
+		t.Errorf("tokens = %q, %q; want distinct 64-character hex strings", a, b)
+	}
+}
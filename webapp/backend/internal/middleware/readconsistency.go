@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"backend/internal/repository"
+)
+
+// 読み取りの整合性を指定するヘッダー。"strong" ならプライマリから読む
+const ReadConsistencyHeader = "X-Read-Consistency"
+
+// X-Read-Consistency: strong が付いたリクエストの読み取りをプライマリに向ける
+// 書き込み直後に結果を確認したいクライアント向けの逃げ道
+func ReadConsistency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get(ReadConsistencyHeader), "strong") {
+			r = r.WithContext(repository.WithPrimary(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// 全ての読み取りをプライマリに向ける
+// 更新した内容をすぐに読み返す管理 API で使う
+func PrimaryReads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(repository.WithPrimary(r.Context())))
+	})
+}
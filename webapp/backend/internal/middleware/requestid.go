@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+
+	"backend/internal/logging"
+
+	"github.com/google/uuid"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// 受け取ったリクエストIDの最大長。これを超えるものは採用せず振り直す
+const maxRequestIDLength = 128
+
+// リクエストIDをコンテキストとレスポンスヘッダーに設定する
+// 上流(ロードバランサーなど)が付けた X-Request-ID があればそれを引き継ぐ
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// ログやヘッダーにそのまま載せられる文字だけで構成されているか
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
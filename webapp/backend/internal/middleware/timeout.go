@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"backend/internal/apperror"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
+)
+
+// リクエスト全体の処理時間に上限を設ける
+// ハンドラーに渡すコンテキストに期限を付け、期限切れで何も書かずに戻ったハンドラーの代わりに 504 を返す
+// レスポンスはバッファしない。期限を無視して動き続けるハンドラーは止められないので、サーバーの WriteTimeout と併用すること
+func HandlerTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			ww := chimw.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			if ww.Status() == 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				apperror.Write(w, r, apperror.Wrap(apperror.CodeTimeout, "The operation timed out", ctx.Err()))
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// schema_migrations の読み書きとロックだけを真似る MySQL の代わり
+// それ以外の文はマイグレーションの中身として記録し、errs に登録したエラーを返す
+type fakeDB struct {
+	mu       sync.Mutex
+	noTable  bool
+	lockFree bool
+	records  map[int64]record
+	executed []string
+	errs     map[string]error
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{noTable: true, lockFree: true, records: map[int64]record{}, errs: map[string]error{}}
+}
+
+// db を使う Migrator を作る
+func newFakeMigrator(t *testing.T, db *fakeDB, migrations []Migration) *Migrator {
+	t.Helper()
+	sqlDB := sqlx.NewDb(sql.OpenDB(db), "mysql")
+	t.Cleanup(func() { sqlDB.Close() })
+	return &Migrator{db: sqlDB, migrations: migrations, logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+// 実行したマイグレーションの文を返して記録を消す
+func (db *fakeDB) takeExecuted() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	executed := db.executed
+	db.executed = nil
+	return executed
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("transactions not supported") }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		db.noTable = false
+	case strings.HasPrefix(query, "SELECT RELEASE_LOCK"):
+		db.lockFree = true
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		version := args[0].Value.(int64)
+		db.records[version] = record{Version: version, Name: args[1].Value.(string), Checksum: args[2].Value.(string), Dirty: true, AppliedAt: time.Now()}
+	case strings.HasPrefix(query, "UPDATE schema_migrations SET dirty = FALSE"):
+		db.setDirty(args[0].Value.(int64), false)
+	case strings.HasPrefix(query, "UPDATE schema_migrations SET dirty = TRUE"):
+		db.setDirty(args[0].Value.(int64), true)
+	case strings.HasPrefix(query, "DELETE FROM schema_migrations"):
+		delete(db.records, args[0].Value.(int64))
+	default:
+		db.executed = append(db.executed, query)
+		if err := db.errs[query]; err != nil {
+			return nil, err
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (db *fakeDB) setDirty(version int64, dirty bool) {
+	rec := db.records[version]
+	rec.Dirty = dirty
+	db.records[version] = rec
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "SELECT GET_LOCK"):
+		got := int64(0)
+		if db.lockFree {
+			db.lockFree = false
+			got = 1
+		}
+		return &fakeRows{columns: []string{"got"}, values: [][]driver.Value{{got}}}, nil
+	case strings.HasPrefix(query, "SELECT version, name, checksum, dirty, applied_at FROM schema_migrations"):
+		if db.noTable {
+			return nil, &mysql.MySQLError{Number: errNoSuchTable, Message: "Table 'schema_migrations' doesn't exist"}
+		}
+		rows := &fakeRows{columns: []string{"version", "name", "checksum", "dirty", "applied_at"}}
+		for _, v := range slices.Sorted(maps.Keys(db.records)) {
+			rec := db.records[v]
+			rows.values = append(rows.values, []driver.Value{rec.Version, rec.Name, rec.Checksum, rec.Dirty, rec.AppliedAt})
+		}
+		return rows, nil
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
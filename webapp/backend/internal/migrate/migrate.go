@@ -0,0 +1,335 @@
+// バイナリに埋め込んだ SQL ファイルでスキーマを管理する
+// 適用履歴は schema_migrations テーブルに残す
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration has been modified")
+	ErrNoDownMigration  = errors.New("down migration not found")
+	ErrLockTimeout      = errors.New("timed out waiting for migration lock")
+)
+
+// 他のプロセスと同時に適用しないためのロック
+const (
+	lockName    = "schema_migrations"
+	lockTimeout = 60 * time.Second
+)
+
+// ファイル名は <バージョン>_<名前>.up.sql / <バージョン>_<名前>.down.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// 再実行時に「既に適用済み」とみなして読み飛ばす MySQL のエラー
+// DDL は暗黙にコミットされるため、途中で失敗したマイグレーションをやり直せるようにする
+var alreadyAppliedErrors = map[uint16]string{
+	1050: "table already exists",
+	1051: "unknown table",
+	1060: "duplicate column",
+	1061: "duplicate key name",
+	1091: "column or key does not exist",
+}
+
+// MySQL のテーブルが存在しないエラー
+const errNoSuchTable = 1146
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// up の SHA-256。適用後にファイルが書き換えられていないか確かめる
+	Checksum string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+// fsys の直下にある SQL ファイルを読み込み、バージョン順に並べる
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		match := fileNamePattern.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", e.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", e.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		} else if mig.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by both %q and %q", version, mig.Name, match[2])
+		}
+		if match[3] == "up" {
+			mig.Up = string(body)
+			sum := sha256.Sum256(body)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Checksum == "" {
+			return nil, fmt.Errorf("migration %s has no up file", mig)
+		}
+		migrations = append(migrations, *mig)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return cmp.Compare(a.Version, b.Version) })
+	return migrations, nil
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+	logger     *slog.Logger
+}
+
+// 埋め込んだマイグレーションを使う Migrator を作る
+func New(db *sqlx.DB, logger *slog.Logger) (*Migrator, error) {
+	fsys, err := fs.Sub(embedded, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, logger: logger}, nil
+}
+
+// schema_migrations の1行
+type record struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	Dirty     bool      `db:"dirty"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// 未適用のマイグレーションを順に適用し、適用した件数を返す
+// 前回途中で失敗したもの(dirty)はやり直す
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	conn, release, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return 0, err
+	}
+	records, err := loadRecords(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	applied := make(map[int64]record, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+
+	// 適用済みのファイルが書き換えられていたら何もしない
+	for _, mig := range m.migrations {
+		if rec, ok := applied[mig.Version]; ok && !rec.Dirty && rec.Checksum != mig.Checksum {
+			return 0, fmt.Errorf("%w: %s", ErrChecksumMismatch, mig)
+		}
+	}
+
+	n := 0
+	for _, mig := range m.migrations {
+		rec, ok := applied[mig.Version]
+		if ok && !rec.Dirty {
+			continue
+		}
+		if ok {
+			m.logger.WarnContext(ctx, "retrying dirty migration", "migration", mig.String())
+		}
+		if err := m.apply(ctx, conn, mig); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// 適用済みのマイグレーションを新しい順に steps 件取り消し、取り消した件数を返す
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	conn, release, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return 0, err
+	}
+	records, err := loadRecords(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	slices.Reverse(records)
+
+	n := 0
+	for _, rec := range records {
+		if n >= steps {
+			break
+		}
+		i := slices.IndexFunc(m.migrations, func(mig Migration) bool { return mig.Version == rec.Version })
+		if i < 0 || m.migrations[i].Down == "" {
+			return n, fmt.Errorf("%w: %d_%s", ErrNoDownMigration, rec.Version, rec.Name)
+		}
+		if err := m.revert(ctx, conn, m.migrations[i]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sqlx.Conn, mig Migration) error {
+	start := time.Now()
+	// 失敗したときに dirty のまま残るよう、先に記録する
+	_, err := conn.ExecContext(ctx, `
+		INSERT INTO schema_migrations (version, name, checksum, dirty, applied_at)
+		VALUES (?, ?, ?, TRUE, NOW())
+		ON DUPLICATE KEY UPDATE name = VALUES(name), checksum = VALUES(checksum), dirty = TRUE
+	`, mig.Version, mig.Name, mig.Checksum)
+	if err != nil {
+		return fmt.Errorf("record migration %s: %w", mig, err)
+	}
+	if err := m.exec(ctx, conn, mig, mig.Up); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `UPDATE schema_migrations SET dirty = FALSE, applied_at = NOW() WHERE version = ?`, mig.Version)
+	if err != nil {
+		return fmt.Errorf("record migration %s: %w", mig, err)
+	}
+	m.logger.InfoContext(ctx, "migration applied", "migration", mig.String(), "duration_ms", time.Since(start).Milliseconds())
+	return nil
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sqlx.Conn, mig Migration) error {
+	start := time.Now()
+	if _, err := conn.ExecContext(ctx, `UPDATE schema_migrations SET dirty = TRUE WHERE version = ?`, mig.Version); err != nil {
+		return fmt.Errorf("record migration %s: %w", mig, err)
+	}
+	if err := m.exec(ctx, conn, mig, mig.Down); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, mig.Version); err != nil {
+		return fmt.Errorf("record migration %s: %w", mig, err)
+	}
+	m.logger.InfoContext(ctx, "migration reverted", "migration", mig.String(), "duration_ms", time.Since(start).Milliseconds())
+	return nil
+}
+
+// SQL を1文ずつ実行する
+// 既に適用済みであることを示すエラーは警告を出して読み飛ばす
+func (m *Migrator) exec(ctx context.Context, conn *sqlx.Conn, mig Migration, src string) error {
+	for _, stmt := range splitStatements(src) {
+		_, err := conn.ExecContext(ctx, stmt)
+		if err == nil {
+			continue
+		}
+		var myErr *mysql.MySQLError
+		if errors.As(err, &myErr) {
+			if reason, ok := alreadyAppliedErrors[myErr.Number]; ok {
+				m.logger.WarnContext(ctx, "migration statement skipped", "migration", mig.String(), "reason", reason, "error", err)
+				continue
+			}
+		}
+		return fmt.Errorf("migration %s: %w", mig, err)
+	}
+	return nil
+}
+
+// 専用のコネクションで名前付きロックを取る
+// 解放関数はロックを外してコネクションを返す
+func (m *Migrator) lock(ctx context.Context) (*sqlx.Conn, func(), error) {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var got *int
+	if err := conn.GetContext(ctx, &got, `SELECT GET_LOCK(?, ?)`, lockName, int(lockTimeout.Seconds())); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if got == nil || *got != 1 {
+		conn.Close()
+		return nil, nil, ErrLockTimeout
+	}
+	release := func() {
+		// ctx が取り消されていてもロックは外す
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT RELEASE_LOCK(?)`, lockName); err != nil {
+			m.logger.WarnContext(ctx, "failed to release migration lock", "error", err)
+		}
+		conn.Close()
+	}
+	return conn, release, nil
+}
+
+func ensureTable(ctx context.Context, conn *sqlx.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			checksum CHAR(64) NOT NULL,
+			dirty BOOLEAN NOT NULL DEFAULT FALSE,
+			applied_at DATETIME NOT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4
+	`)
+	return err
+}
+
+// 適用履歴をバージョン順に返す。テーブルがなければ空とする
+func loadRecords(ctx context.Context, q sqlx.QueryerContext) ([]record, error) {
+	var records []record
+	err := sqlx.SelectContext(ctx, q, &records, `
+		SELECT version, name, checksum, dirty, applied_at
+		FROM schema_migrations
+		ORDER BY version
+	`)
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) && myErr.Number == errNoSuchTable {
+		return nil, nil
+	}
+	return records, err
+}
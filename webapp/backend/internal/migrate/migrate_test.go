@@ -0,0 +1,247 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func mapFS(files map[string]string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for name, body := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(body)}
+	}
+	return fsys
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(mapFS(map[string]string{
+		"10_add_index.up.sql":     "CREATE INDEX i ON t (a)",
+		"2_create_table.up.sql":   "CREATE TABLE t (a INT)",
+		"2_create_table.down.sql": "DROP TABLE t",
+	}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var names []string
+	for _, m := range migrations {
+		names = append(names, m.String())
+	}
+	if want := []string{"2_create_table", "10_add_index"}; !slices.Equal(names, want) {
+		t.Fatalf("migrations = %v, want %v", names, want)
+	}
+	if migrations[0].Down != "DROP TABLE t" || migrations[1].Down != "" {
+		t.Errorf("down = %q, %q", migrations[0].Down, migrations[1].Down)
+	}
+	if len(migrations[0].Checksum) != 64 || migrations[0].Checksum == migrations[1].Checksum {
+		t.Errorf("checksums = %q, %q", migrations[0].Checksum, migrations[1].Checksum)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"missing up file", map[string]string{"1_a.up.sql": "SELECT 1", "2_b.down.sql": "SELECT 2"}, "2_b has no up file"},
+		{"duplicate version", map[string]string{"1_a.up.sql": "SELECT 1", "1_b.up.sql": "SELECT 2"}, "version 1 is used by both"},
+		{"invalid file name", map[string]string{"1_a.sql": "SELECT 1"}, "invalid migration file name"},
+		{"invalid version", map[string]string{"99999999999999999999_a.up.sql": "SELECT 1"}, "invalid migration version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(mapFS(tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// 埋め込んだマイグレーションはすべて読み込め、取り消せる
+func TestEmbeddedMigrations(t *testing.T) {
+	fsys, err := fs.Sub(embedded, "migrations")
+	if err != nil {
+		t.Fatalf("Sub: %v", err)
+	}
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for _, m := range migrations {
+		if len(splitStatements(m.Up)) == 0 || len(splitStatements(m.Down)) == 0 {
+			t.Errorf("%s: up or down has no statements", m)
+		}
+	}
+}
+
+func testMigrations(t *testing.T) []Migration {
+	t.Helper()
+	migrations, err := Load(mapFS(map[string]string{
+		"1_create.up.sql":   "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);",
+		"1_create.down.sql": "DROP TABLE b;\nDROP TABLE a;",
+		"2_index.up.sql":    "ALTER TABLE a ADD INDEX i (id);",
+		"2_index.down.sql":  "ALTER TABLE a DROP INDEX i;",
+	}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return migrations
+}
+
+func TestMigratorUp(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDB()
+	m := newFakeMigrator(t, db, testMigrations(t))
+
+	n, err := m.Up(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("Up = %d, %v; want 2", n, err)
+	}
+	want := []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)", "ALTER TABLE a ADD INDEX i (id)"}
+	if got := db.takeExecuted(); !slices.Equal(got, want) {
+		t.Errorf("executed = %q, want %q", got, want)
+	}
+	for _, rec := range db.records {
+		if rec.Dirty {
+			t.Errorf("%d_%s left dirty", rec.Version, rec.Name)
+		}
+	}
+
+	// 適用済みなら何もしない
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Errorf("second Up = %d, %v; want 0", n, err)
+	}
+	if got := db.takeExecuted(); len(got) != 0 {
+		t.Errorf("second Up executed %q", got)
+	}
+	if !db.lockFree {
+		t.Error("migration lock was not released")
+	}
+}
+
+func TestMigratorUpRetriesDirty(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDB()
+	m := newFakeMigrator(t, db, testMigrations(t))
+
+	errBroken := errors.New("broken")
+	db.errs["CREATE TABLE b (id INT)"] = errBroken
+	if n, err := m.Up(ctx); !errors.Is(err, errBroken) || n != 0 {
+		t.Fatalf("Up = %d, %v; want 0, %v", n, err, errBroken)
+	}
+	if rec := db.records[1]; !rec.Dirty {
+		t.Fatal("failed migration is not dirty")
+	}
+	if _, ok := db.records[2]; ok {
+		t.Fatal("migration after the failure was recorded")
+	}
+	db.takeExecuted()
+
+	// やり直すと、前回適用できた文の「既に存在する」エラーは読み飛ばす
+	db.errs["CREATE TABLE b (id INT)"] = nil
+	db.errs["CREATE TABLE a (id INT)"] = &mysql.MySQLError{Number: 1050, Message: "Table 'a' already exists"}
+	if n, err := m.Up(ctx); err != nil || n != 2 {
+		t.Fatalf("retry Up = %d, %v; want 2", n, err)
+	}
+	if got := db.takeExecuted(); len(got) != 3 {
+		t.Errorf("retry executed %q, want all 3 statements", got)
+	}
+	if db.records[1].Dirty || db.records[2].Dirty {
+		t.Error("migrations still dirty after retry")
+	}
+}
+
+func TestMigratorUpRejectsModifiedMigration(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDB()
+	migrations := testMigrations(t)
+	if _, err := newFakeMigrator(t, db, migrations[:1]).Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	db.takeExecuted()
+
+	migrations[0].Checksum = strings.Repeat("0", 64)
+	n, err := newFakeMigrator(t, db, migrations).Up(ctx)
+	if !errors.Is(err, ErrChecksumMismatch) || n != 0 {
+		t.Errorf("Up = %d, %v; want %v", n, err, ErrChecksumMismatch)
+	}
+	if got := db.takeExecuted(); len(got) != 0 {
+		t.Errorf("executed %q after a checksum mismatch", got)
+	}
+}
+
+func TestMigratorLockTimeout(t *testing.T) {
+	db := newFakeDB()
+	db.lockFree = false
+	m := newFakeMigrator(t, db, testMigrations(t))
+	if _, err := m.Up(context.Background()); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Up error = %v, want %v", err, ErrLockTimeout)
+	}
+	if _, err := m.Down(context.Background(), 1); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Down error = %v, want %v", err, ErrLockTimeout)
+	}
+}
+
+func TestMigratorDown(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDB()
+	m := newFakeMigrator(t, db, testMigrations(t))
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	db.takeExecuted()
+
+	if n, err := m.Down(ctx, 1); err != nil || n != 1 {
+		t.Fatalf("Down(1) = %d, %v; want 1", n, err)
+	}
+	if got, want := db.takeExecuted(), []string{"ALTER TABLE a DROP INDEX i"}; !slices.Equal(got, want) {
+		t.Errorf("executed = %q, want %q", got, want)
+	}
+	if _, ok := db.records[2]; ok {
+		t.Error("reverted migration is still recorded")
+	}
+
+	// 適用済みの件数より多く指定しても、あるだけ取り消す
+	if n, err := m.Down(ctx, 5); err != nil || n != 1 {
+		t.Fatalf("Down(5) = %d, %v; want 1", n, err)
+	}
+	if got, want := db.takeExecuted(), []string{"DROP TABLE b", "DROP TABLE a"}; !slices.Equal(got, want) {
+		t.Errorf("executed = %q, want %q", got, want)
+	}
+	if len(db.records) != 0 {
+		t.Errorf("records = %v, want none", db.records)
+	}
+}
+
+func TestMigratorDownWithoutDownFile(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDB()
+	migrations := testMigrations(t)
+	m := newFakeMigrator(t, db, migrations)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	migrations[1].Down = ""
+	if n, err := m.Down(ctx, 2); !errors.Is(err, ErrNoDownMigration) || n != 0 {
+		t.Errorf("Down = %d, %v; want 0, %v", n, err, ErrNoDownMigration)
+	}
+	// ファイルのないマイグレーションも取り消せない
+	if n, err := newFakeMigrator(t, db, migrations[:1]).Down(ctx, 1); !errors.Is(err, ErrNoDownMigration) || n != 0 {
+		t.Errorf("Down without the file = %d, %v; want 0, %v", n, err, ErrNoDownMigration)
+	}
+	if len(db.records) != 2 {
+		t.Errorf("records = %v, want both migrations still applied", db.records)
+	}
+}
@@ -1,5 +1,3 @@
-USE `42Tokyo2508-db`;
-
 CREATE TABLE IF NOT EXISTS shipping_order_cache (
     order_id INT UNSIGNED PRIMARY KEY,
     weight INT UNSIGNED NOT NULL,
@@ -12,7 +10,8 @@ COLLATE=utf8mb4_0900_ai_ci;
 
 ALTER TABLE `shipping_order_cache` ADD INDEX `idx_weight_value` (`weight`, `value` DESC);
 
-INSERT INTO shipping_order_cache (order_id, weight, value)
+-- 途中で失敗して再実行しても重複しないよう IGNORE を付ける
+INSERT IGNORE INTO shipping_order_cache (order_id, weight, value)
 SELECT o.order_id, p.weight, p.value
 FROM orders o
 JOIN products p ON o.product_id = p.product_id
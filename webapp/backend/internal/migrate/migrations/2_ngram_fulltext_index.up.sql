@@ -0,0 +1,4 @@
+-- インデックスが無い場合の削除エラーはランナーが無視する
+ALTER TABLE products DROP INDEX idx_name_desc_fulltext;
+
+ALTER TABLE products ADD FULLTEXT INDEX idx_name_desc_fulltext (name, description) WITH PARSER ngram;
@@ -0,0 +1,3 @@
+ALTER TABLE `orders` DROP INDEX `idx_batch_token`;
+
+ALTER TABLE `orders` DROP COLUMN `batch_token`;
@@ -0,0 +1,4 @@
+-- 一括注文で挿入した行を見分けるためのトークン。AUTO_INCREMENT の連番には頼らない
+ALTER TABLE `orders` ADD COLUMN `batch_token` CHAR(36) NULL;
+
+ALTER TABLE `orders` ADD INDEX `idx_batch_token` (`batch_token`);
@@ -0,0 +1,17 @@
+-- ユーザーごと・ステータスごとの注文数。注文一覧の件数を COUNT(*) せずに返すために使う
+CREATE TABLE IF NOT EXISTS user_order_counts (
+    user_id INT UNSIGNED NOT NULL,
+    shipped_status VARCHAR(50) NOT NULL,
+    order_count INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, shipped_status),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+) ENGINE=InnoDB
+DEFAULT CHARSET=utf8mb4
+COLLATE=utf8mb4_0900_ai_ci;
+
+-- 途中で失敗して再実行しても二重に数えないよう、数え直した値で上書きする
+INSERT INTO user_order_counts (user_id, shipped_status, order_count)
+SELECT user_id, shipped_status, COUNT(*)
+FROM orders
+GROUP BY user_id, shipped_status
+ON DUPLICATE KEY UPDATE order_count = VALUES(order_count);
@@ -0,0 +1,74 @@
+package migrate
+
+import "strings"
+
+// SQL ファイルの内容を文ごとに分ける
+// 文字列・識別子の引用符とコメントの中のセミコロンは区切りとみなさない。コメントは取り除く
+func splitStatements(src string) []string {
+	var stmts []string
+	var b strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(b.String()); s != "" {
+			stmts = append(stmts, s)
+		}
+		b.Reset()
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := closingQuote(src, i)
+			b.WriteString(src[i:end])
+			i = end - 1
+		case c == '#' || isDashComment(src, i):
+			// 行末まで読み飛ばす
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			b.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 3
+			}
+			b.WriteByte(' ')
+		case c == ';':
+			flush()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return stmts
+}
+
+// MySQL の "--" コメントは直後に空白か制御文字が必要
+func isDashComment(src string, i int) bool {
+	if !strings.HasPrefix(src[i:], "--") {
+		return false
+	}
+	return i+2 == len(src) || src[i+2] <= ' '
+}
+
+// start の引用符に対応する閉じ引用符の次の位置を返す
+// 閉じられていなければ末尾を返す
+func closingQuote(src string, start int) int {
+	q := src[start]
+	for i := start + 1; i < len(src); i++ {
+		switch {
+		case src[i] == '\\' && q != '`':
+			i++
+		case src[i] == q:
+			// 引用符を2つ重ねたものはエスケープ
+			if i+1 < len(src) && src[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(src)
+}
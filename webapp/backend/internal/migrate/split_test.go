@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"plain", "CREATE TABLE a (id INT);\nDROP TABLE b;", []string{"CREATE TABLE a (id INT)", "DROP TABLE b"}},
+		{"no trailing semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"empty statements", " ;\n;SELECT 1;;", []string{"SELECT 1"}},
+		{"semicolon in single quotes", "INSERT INTO t VALUES ('a;b'); SELECT 1", []string{"INSERT INTO t VALUES ('a;b')", "SELECT 1"}},
+		{"semicolon in double quotes", `INSERT INTO t VALUES ("a;b"); SELECT 1`, []string{`INSERT INTO t VALUES ("a;b")`, "SELECT 1"}},
+		{"semicolon in backticks", "ALTER TABLE `a;b` ADD c INT; SELECT 1", []string{"ALTER TABLE `a;b` ADD c INT", "SELECT 1"}},
+		{"doubled quote", "SELECT 'it''s;'; SELECT 1", []string{"SELECT 'it''s;'", "SELECT 1"}},
+		{"backslash escaped quote", `SELECT 'it\'s;'; SELECT 1`, []string{`SELECT 'it\'s;'`, "SELECT 1"}},
+		{"doubled backtick", "SELECT `a``;b`; SELECT 1", []string{"SELECT `a``;b`", "SELECT 1"}},
+		{"dash comment", "SELECT 1; -- drop; this\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"dash comment at end", "SELECT 1 --", []string{"SELECT 1"}},
+		{"double minus without space", "SELECT 1--x;\nSELECT 2", []string{"SELECT 1--x", "SELECT 2"}},
+		{"hash comment", "# setup; step\nSELECT 1; # trailing;\nSELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"block comment", "SELECT /* ; */ 1; SELECT 2", []string{"SELECT   1", "SELECT 2"}},
+		{"unterminated block comment", "SELECT 1; /* ; SELECT 2", []string{"SELECT 1"}},
+		{"comment marker in quotes", "SELECT '-- ;', '# ;', '/* ; */'", []string{"SELECT '-- ;', '# ;', '/* ; */'"}},
+		{"unterminated quote", "SELECT 1; SELECT 'a; SELECT 2", []string{"SELECT 1", "SELECT 'a; SELECT 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.src); !slices.Equal(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDashComment(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"-- x", true},
+		{"--\tx", true},
+		{"--\nx", true},
+		{"--", true},
+		{"--x", false},
+		{"-x", false},
+		{"- - x", false},
+	}
+	for _, tt := range tests {
+		if got := isDashComment(tt.src, 0); got != tt.want {
+			t.Errorf("isDashComment(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestClosingQuote(t *testing.T) {
+	tests := []struct {
+		src  string
+		want int
+	}{
+		{"'abc' x", 5},
+		{"'' x", 2},
+		{"'a''b' x", 6},
+		{`'a\'b' x`, 6},
+		{`'a\\' x`, 5},
+		{`"a""b" x`, 6},
+		{`"a\"b" x`, 6},
+		{"`a``b` x", 6},
+		// 識別子ではバックスラッシュはエスケープではない
+		{"`a\\` x", 4},
+		{"'abc", 4},
+		{`'a\`, 3},
+	}
+	for _, tt := range tests {
+		if got := closingQuote(tt.src, 0); got != tt.want {
+			t.Errorf("closingQuote(%q) = %d, want %d", tt.src, got, tt.want)
+		}
+	}
+}
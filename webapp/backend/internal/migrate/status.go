@@ -0,0 +1,67 @@
+package migrate
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	StatePending  = "pending"
+	StateApplied  = "applied"
+	StateDirty    = "dirty"
+	StateModified = "modified"
+	// 適用済みだが対応するファイルがない
+	StateMissing = "missing"
+)
+
+type Status struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// ファイルと適用履歴を突き合わせ、バージョン順に返す
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	records, err := loadRecords(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]record, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+
+	var statuses []Status
+	known := make(map[int64]bool, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		st := Status{Version: mig.Version, Name: mig.Name, State: StatePending}
+		if rec, ok := applied[mig.Version]; ok {
+			st.AppliedAt = &rec.AppliedAt
+			switch {
+			case rec.Dirty:
+				st.State = StateDirty
+			case rec.Checksum != mig.Checksum:
+				st.State = StateModified
+			default:
+				st.State = StateApplied
+			}
+		}
+		statuses = append(statuses, st)
+	}
+	for _, rec := range records {
+		if !known[rec.Version] {
+			statuses = append(statuses, Status{Version: rec.Version, Name: rec.Name, State: StateMissing, AppliedAt: &rec.AppliedAt})
+		}
+	}
+	return statuses, nil
+}
+
+// 埋め込んだ中で最新のバージョン
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
@@ -0,0 +1,54 @@
+package migrate
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigratorStatus(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDB()
+	migrations := testMigrations(t)
+	m := newFakeMigrator(t, db, migrations)
+
+	// schema_migrations がまだ無ければすべて未適用
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, st := range statuses {
+		if st.State != StatePending || st.AppliedAt != nil {
+			t.Errorf("%d_%s: state = %s, applied at %v; want pending", st.Version, st.Name, st.State, st.AppliedAt)
+		}
+	}
+	if got := m.Latest(); got != 2 {
+		t.Errorf("Latest = %d, want 2", got)
+	}
+
+	now := time.Now()
+	db.noTable = false
+	db.records[1] = record{Version: 1, Name: "create", Checksum: migrations[0].Checksum, AppliedAt: now}
+	db.records[2] = record{Version: 2, Name: "index", Checksum: migrations[1].Checksum, Dirty: true, AppliedAt: now}
+	db.records[3] = record{Version: 3, Name: "removed", Checksum: strings.Repeat("0", 64), AppliedAt: now}
+	migrations = append(migrations, Migration{Version: 4, Name: "pending", Up: "SELECT 1", Checksum: strings.Repeat("1", 64)})
+	migrations[0].Checksum = strings.Repeat("2", 64)
+
+	statuses, err = newFakeMigrator(t, db, migrations).Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	var got []string
+	for _, st := range statuses {
+		got = append(got, st.Name+"="+st.State)
+		if (st.State == StatePending) != (st.AppliedAt == nil) {
+			t.Errorf("%s: applied at = %v", st.Name, st.AppliedAt)
+		}
+	}
+	want := []string{"create=" + StateModified, "index=" + StateDirty, "pending=" + StatePending, "removed=" + StateMissing}
+	if !slices.Equal(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+}
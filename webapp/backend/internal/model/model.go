@@ -5,10 +5,16 @@ import (
 	"time"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	UserID       int    `db:"user_id"`
 	PasswordHash string `db:"password_hash"`
 	UserName     string `db:"user_name"`
+	Role         string `db:"role"`
 }
 
 type Product struct {
@@ -39,31 +45,83 @@ type DeliveryPlan struct {
 	Orders      []Order `json:"orders"`
 }
 
+// shipping_order_cache と orders JOIN products のずれ。値はいずれも注文ID
+type ShippingCacheDrift struct {
+	// 配送待ちなのにキャッシュにない
+	Missing []int64 `json:"missing"`
+	// 重量・価値が商品と食い違っている
+	Stale []int64 `json:"stale"`
+	// 配送待ちでない、または存在しない注文のキャッシュ
+	Orphaned []int64 `json:"orphaned"`
+}
+
+func (d ShippingCacheDrift) Total() int {
+	return len(d.Missing) + len(d.Stale) + len(d.Orphaned)
+}
+
+// 管理者による商品の作成・更新リクエスト
+type ProductRequest struct {
+	Name        string `json:"name"        validate:"required,max=255"`
+	Value       int    `json:"value"       validate:"min=0"`
+	Weight      int    `json:"weight"      validate:"gt=0"`
+	Image       string `json:"image"       validate:"max=255"`
+	Description string `json:"description" validate:"max=10000"`
+}
+
 type LoginRequest struct {
-	UserName string `json:"user_name"`
-	Password string `json:"password"`
+	UserName string `json:"user_name" validate:"required,max=255"`
+	Password string `json:"password"  validate:"required,max=72"`
+}
+
+type TokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int       `json:"expires_in"`
+	ExpiresAt   time.Time `json:"expires_at"`
 }
 
 type CreateOrderRequest struct {
-	Items []RequestItem `json:"items"`
+	Items []RequestItem `json:"items" validate:"required,min=1,max=100,dive"`
 }
 
 type RequestItem struct {
-	ProductID int `json:"product_id"`
-	Quantity  int `json:"quantity"`
+	ProductID int `json:"product_id" validate:"gt=0"`
+	Quantity  int `json:"quantity"   validate:"gt=0,max=1000"`
 }
 
 type UpdateOrderStatusRequest struct {
-	OrderID   int64  `json:"order_id"`
-	NewStatus string `json:"new_status"`
+	OrderID   int64  `json:"order_id"   validate:"gt=0"`
+	NewStatus string `json:"new_status" validate:"required,oneof=shipping delivering completed"`
 }
 
 type ListRequest struct {
-	Search    string `json:"search"`
-	Type      string `json:"type"`
-	Page      int    `json:"page"`
-	PageSize  int    `json:"page_size"`
-	SortField string `json:"sort_field"`
-	SortOrder string `json:"sort_order"`
-	Offset    int    `json:"-"`
+	Search    string `json:"search"     validate:"max=255"`
+	Type      string `json:"type"       validate:"omitempty,oneof=partial prefix fulltext"`
+	Page      int    `json:"page"       validate:"min=0,max=100000"`
+	PageSize  int    `json:"page_size"  validate:"min=0,max=100"`
+	SortField string `json:"sort_field" validate:"max=64"`
+	SortOrder string `json:"sort_order" validate:"omitempty,oneofci=asc desc"`
+	// 件数の数え方。注文一覧だけが見る。未指定なら exact
+	Total  string `json:"total"      validate:"omitempty,oneof=exact approximate none"`
+	Offset int    `json:"-"`
+}
+
+// ListRequest.Total に指定できる値
+const (
+	// 正確に数える
+	ListTotalExact = "exact"
+	// 検索では上限まで数え、超えた分は数えない
+	ListTotalApproximate = "approximate"
+	// 数えずに HasMore だけを返す
+	ListTotalNone = "none"
+)
+
+// 一覧の 1 ページと一緒に返す件数の情報
+type ListPage struct {
+	// 数えなかった場合は nil
+	Total *int
+	// Total が上限で打ち切った値で、実際はそれ以上あるかもしれない
+	TotalApproximate bool
+	// 次のページに続きがあるか
+	HasMore bool
 }
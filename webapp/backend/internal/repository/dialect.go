@@ -0,0 +1,67 @@
+package repository
+
+import "strings"
+
+// データベースごとに書き方が異なる SQL を吸収する
+// 本番は MySQL、リポジトリのテストは SQLite で動かす
+type Dialect interface {
+	// 現在時刻を表す SQL 式
+	Now() string
+	// columns を term で全文検索する WHERE 条件と、その引数
+	FullTextMatch(columns []string, term string) (string, []any)
+	// SELECT の末尾に付け、読んだ行をトランザクションの終わりまでロックする句
+	ForUpdate() string
+	// INSERT の末尾に付け、keys が重複したら column に挿入しようとした値を足す句
+	OnConflictAdd(keys []string, column string) string
+}
+
+var (
+	MySQL  Dialect = mysqlDialect{}
+	SQLite Dialect = sqliteDialect{}
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Now() string {
+	return "NOW()"
+}
+
+// ngram パーサーの FULLTEXT インデックスを使う
+func (mysqlDialect) FullTextMatch(columns []string, term string) (string, []any) {
+	return "MATCH(" + strings.Join(columns, ", ") + ") AGAINST(? IN BOOLEAN MODE)", []any{term}
+}
+
+func (mysqlDialect) ForUpdate() string {
+	return "FOR UPDATE"
+}
+
+func (mysqlDialect) OnConflictAdd(keys []string, column string) string {
+	return "ON DUPLICATE KEY UPDATE " + column + " = " + column + " + VALUES(" + column + ")"
+}
+
+type sqliteDialect struct{}
+
+// MySQL の NOW() にそろえてローカル時刻にする
+func (sqliteDialect) Now() string {
+	return "datetime('now', 'localtime')"
+}
+
+// 全文検索インデックスは使わず、いずれかの列への部分一致で代用する
+func (sqliteDialect) FullTextMatch(columns []string, term string) (string, []any) {
+	conds := make([]string, len(columns))
+	args := make([]any, len(columns))
+	for i, c := range columns {
+		conds[i] = c + " LIKE ?"
+		args[i] = "%" + term + "%"
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// 書き込みはデータベース全体で直列になるので、行ロックは要らない
+func (sqliteDialect) ForUpdate() string {
+	return ""
+}
+
+func (sqliteDialect) OnConflictAdd(keys []string, column string) string {
+	return "ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + column + " = " + column + " + excluded." + column
+}
@@ -2,25 +2,35 @@ package repository
 
 import (
 	"backend/internal/model"
+	"backend/internal/telemetry"
 	"context"
 	"database/sql"
 	"fmt"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type OrderRepository struct {
 	db DBTX
+	// 注文履歴の読み取り用。レプリカに振り分けられることがある
+	read    DBTX
+	dialect Dialect
+	// shipping_order_cache と同じ内容をメモリに持つ索引。コミット後に更新する
+	index *ShippingIndex
+	hooks *commitHooks
 }
 
-func NewOrderRepository(db DBTX) *OrderRepository {
-	return &OrderRepository{db: db}
+func NewOrderRepository(db, read DBTX, dialect Dialect, index *ShippingIndex) *OrderRepository {
+	return &OrderRepository{db: db, read: read, dialect: dialect, index: index}
 }
 
 // 注文を作成し、生成された注文IDを返す
 func (r *OrderRepository) Create(ctx context.Context, order *model.Order) (string, error) {
-	query := `INSERT INTO orders (user_id, product_id, shipped_status, created_at) VALUES (?, ?, 'shipping', NOW())`
+	query := fmt.Sprintf(`INSERT INTO orders (user_id, product_id, shipped_status, created_at) VALUES (?, ?, 'shipping', %s)`, r.dialect.Now())
 	result, err := r.db.ExecContext(ctx, query, order.UserID, order.ProductID)
 	if err != nil {
 		return "", err
@@ -30,70 +40,114 @@ func (r *OrderRepository) Create(ctx context.Context, order *model.Order) (strin
 		return "", err
 	}
 
-	cacheQuery := `
-		INSERT INTO shipping_order_cache (order_id, weight, value)
-		SELECT ?, p.weight, p.value
-		FROM products p
-		WHERE p.product_id = ?
-	`
-	_, err = r.db.ExecContext(ctx, cacheQuery, id, order.ProductID)
+	cached := model.Order{OrderID: id, ProductID: order.ProductID}
+	if err := r.db.GetContext(ctx, &cached, "SELECT weight, value FROM products WHERE product_id = ?", order.ProductID); err != nil {
+		return "", err
+	}
+	_, err = r.db.ExecContext(ctx, "INSERT INTO shipping_order_cache (order_id, weight, value) VALUES (?, ?, ?)", id, cached.Weight, cached.Value)
 	if err != nil {
 		return "", err
 	}
+	if err := applyOrderCounts(ctx, r.db, r.dialect, orderCountDeltas{{UserID: order.UserID, Status: "shipping"}: 1}); err != nil {
+		return "", err
+	}
+	r.hooks.add(func() { r.index.Put(cached) })
 
 	return fmt.Sprintf("%d", id), nil
 }
 
-func (r *OrderRepository) BulkCreate(ctx context.Context, orders []model.Order) ([]string, error) {
+// 注文をまとめて作成し、生成された注文IDを orders と同じ順に返す
+// 採番が連続するとは限らないため、挿入した行は同じ batch_token で見分ける
+func (r *OrderRepository) BulkCreate(ctx context.Context, orders []model.Order) (_ []string, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "repository.order", "OrderRepository.BulkCreate",
+		attribute.Int("order.requested", len(orders)),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	if len(orders) == 0 {
 		return []string{}, nil
 	}
+	batchToken, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	token := batchToken.String()
+
 	valueStrings := make([]string, 0, len(orders))
-	valueArgs := make([]any, 0, len(orders)*2)
+	valueArgs := make([]any, 0, len(orders)*3)
+	counts := make(orderCountDeltas)
 	for _, order := range orders {
-		valueStrings = append(valueStrings, "(?, ?, 'shipping', NOW())")
-		valueArgs = append(valueArgs, order.UserID, order.ProductID)
+		valueStrings = append(valueStrings, "(?, ?, 'shipping', "+r.dialect.Now()+", ?)")
+		valueArgs = append(valueArgs, order.UserID, order.ProductID, token)
+		counts.add(order.UserID, "shipping", 1)
 	}
-	query := fmt.Sprintf("INSERT INTO orders (user_id, product_id, shipped_status, created_at) VALUES %s", strings.Join(valueStrings, ","))
-	result, err := r.db.ExecContext(ctx, query, valueArgs...)
-	if err != nil {
+	query := fmt.Sprintf("INSERT INTO orders (user_id, product_id, shipped_status, created_at, batch_token) VALUES %s", strings.Join(valueStrings, ","))
+	if _, err := r.db.ExecContext(ctx, query, valueArgs...); err != nil {
 		return nil, err
 	}
-	firstID, err := result.LastInsertId()
-	if err != nil {
+
+	// 1 つの INSERT 内では ID は行の順に増えるので、ID 順に並べれば orders と対応する
+	var inserted []model.Order
+	insertedQuery := `
+		SELECT o.order_id, o.product_id, p.weight, p.value
+		FROM orders o
+		JOIN products p ON o.product_id = p.product_id
+		WHERE o.batch_token = ?
+		ORDER BY o.order_id
+	`
+	if err := r.db.SelectContext(ctx, &inserted, insertedQuery, token); err != nil {
 		return nil, err
 	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return nil, err
+	if len(inserted) != len(orders) {
+		return nil, fmt.Errorf("bulk insert: %d orders found for batch %s, want %d", len(inserted), token, len(orders))
 	}
-	orderIDs := make([]string, rowsAffected)
-	for i := range rowsAffected {
-		orderIDs[i] = fmt.Sprintf("%d", firstID+int64(i))
+	orderIDs := make([]string, len(inserted))
+	for i, o := range inserted {
+		orderIDs[i] = fmt.Sprintf("%d", o.OrderID)
 	}
+	span.AddEvent("orders inserted", trace.WithAttributes(
+		attribute.Int64("order.first_id", inserted[0].OrderID),
+		attribute.Int("order.created", len(inserted)),
+	))
 
 	cacheQuery := `
 		INSERT INTO shipping_order_cache (order_id, weight, value)
 		SELECT o.order_id, p.weight, p.value
 		FROM orders o
 		JOIN products p ON o.product_id = p.product_id
-		WHERE o.order_id >= ? AND o.order_id < ?
+		WHERE o.batch_token = ?
 	`
-	_, err = r.db.ExecContext(ctx, cacheQuery, firstID, firstID+int64(len(orderIDs)))
+	_, err = r.db.ExecContext(ctx, cacheQuery, token)
 	if err != nil {
 		return nil, err
 	}
+	if err := applyOrderCounts(ctx, r.db, r.dialect, counts); err != nil {
+		return nil, err
+	}
+	r.hooks.add(func() { r.index.Put(inserted...) })
 
 	return orderIDs, nil
 }
 
 // 複数の注文IDのステータスを一括で更新
 // 主に配送ロボットが注文を引き受けた際に一括更新をするために使用
-func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64, newStatus string) error {
+func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64, newStatus string) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "repository.order", "OrderRepository.UpdateStatuses",
+		attribute.Int("order.count", len(orderIDs)),
+		attribute.String("order.new_status", newStatus),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	if len(orderIDs) == 0 {
 		return nil
 	}
 
+	// 変更前のステータスごとの数を差し引くため、先に数えて行をロックする
+	counts, err := countOrdersForUpdate(ctx, r.db, r.dialect, "order_id IN (?) AND shipped_status <> ?", orderIDs, newStatus)
+	if err != nil {
+		return err
+	}
+
 	if newStatus != "shipping" {
 		deleteQuery, deleteArgs, err := sqlx.In("DELETE FROM shipping_order_cache WHERE order_id IN (?)", orderIDs)
 		if err != nil {
@@ -106,16 +160,18 @@ func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64,
 		}
 	}
 
-	query, args, err := sqlx.In("UPDATE orders SET shipped_status = ? WHERE order_id IN (?)", orderIDs)
+	query, args, err := sqlx.In("UPDATE orders SET shipped_status = ? WHERE order_id IN (?)", newStatus, orderIDs)
 	if err != nil {
 		return err
 	}
 	query = r.db.Rebind(query)
-	args = append([]any{newStatus}, args...)
 	_, err = r.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
+	if err := applyOrderCounts(ctx, r.db, r.dialect, counts.movedTo(newStatus)); err != nil {
+		return err
+	}
 
 	if newStatus == "shipping" {
 		insertQuery, insertArgs, err := sqlx.In(`
@@ -133,11 +189,97 @@ func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64,
 		if err != nil {
 			return err
 		}
+
+		cached, err := r.selectCachedOrders(ctx, orderIDs)
+		if err != nil {
+			return err
+		}
+		r.hooks.add(func() { r.index.Put(cached...) })
+	} else {
+		r.hooks.add(func() { r.index.Remove(orderIDs...) })
 	}
 
 	return nil
 }
 
+// 配送待ちの注文を配送中にし、実際に更新した件数を返す
+// 既に配送待ちでない注文は更新しない。件数が足りなければ、呼び出し側が見ていた索引が古い
+func (r *OrderRepository) ClaimShippingOrders(ctx context.Context, orderIDs []int64) (_ int64, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "repository.order", "OrderRepository.ClaimShippingOrders",
+		attribute.Int("order.count", len(orderIDs)),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	if len(orderIDs) == 0 {
+		return 0, nil
+	}
+	counts, err := countOrdersForUpdate(ctx, r.db, r.dialect, "order_id IN (?) AND shipped_status = 'shipping'", orderIDs)
+	if err != nil {
+		return 0, err
+	}
+	query, args, err := sqlx.In("UPDATE orders SET shipped_status = 'delivering' WHERE order_id IN (?) AND shipped_status = 'shipping'", orderIDs)
+	if err != nil {
+		return 0, err
+	}
+	result, err := r.db.ExecContext(ctx, r.db.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := applyOrderCounts(ctx, r.db, r.dialect, counts.movedTo("delivering")); err != nil {
+		return 0, err
+	}
+
+	deleteQuery, deleteArgs, err := sqlx.In("DELETE FROM shipping_order_cache WHERE order_id IN (?)", orderIDs)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(deleteQuery), deleteArgs...); err != nil {
+		return 0, err
+	}
+	r.hooks.add(func() { r.index.Remove(orderIDs...) })
+
+	span.SetAttributes(attribute.Int64("order.claimed", claimed))
+	return claimed, nil
+}
+
+// 索引に入れるための、shipping_order_cache の内容と商品ID
+func (r *OrderRepository) selectCachedOrders(ctx context.Context, orderIDs []int64) ([]model.Order, error) {
+	query, args, err := sqlx.In(`
+		SELECT c.order_id, o.product_id, c.weight, c.value
+		FROM shipping_order_cache c
+		JOIN orders o ON o.order_id = c.order_id
+		WHERE c.order_id IN (?)
+	`, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	var orders []model.Order
+	err = r.db.SelectContext(ctx, &orders, r.db.Rebind(query), args...)
+	return orders, err
+}
+
+// 商品の重量・価値の変更を配送待ちキャッシュに反映する
+func (r *OrderRepository) SyncShippingCacheByProduct(ctx context.Context, productID int) error {
+	var product model.Product
+	if err := r.db.GetContext(ctx, &product, "SELECT weight, value FROM products WHERE product_id = ?", productID); err != nil {
+		return err
+	}
+	query := `
+		UPDATE shipping_order_cache
+		SET weight = ?, value = ?
+		WHERE order_id IN (SELECT order_id FROM orders WHERE product_id = ?)
+	`
+	if _, err := r.db.ExecContext(ctx, query, product.Weight, product.Value, productID); err != nil {
+		return err
+	}
+	r.hooks.add(func() { r.index.UpdateProduct(productID, product.Weight, product.Value) })
+	return nil
+}
+
 // 配送中(shipped_status:shipping)の注文一覧を取得
 func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order, error) {
 	var orders []model.Order
@@ -157,7 +299,12 @@ func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order,
 // 配送中(shipped_status:shipping)の注文一覧を効率的に取得
 // maxWeight: ロボットの積載容量
 // limit: 取得する最大件数
-func (r *OrderRepository) GetShippingOrdersOptimized(ctx context.Context, maxWeight int) ([]model.Order, error) {
+func (r *OrderRepository) GetShippingOrdersOptimized(ctx context.Context, maxWeight int) (_ []model.Order, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "repository.order", "OrderRepository.GetShippingOrdersOptimized",
+		attribute.Int("robot.capacity", maxWeight),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	var orders []model.Order
 	query := `
         SELECT
@@ -168,12 +315,23 @@ func (r *OrderRepository) GetShippingOrdersOptimized(ctx context.Context, maxWei
         WHERE weight <= ?
         ORDER BY value DESC
     `
-	err := r.db.SelectContext(ctx, &orders, query, maxWeight)
+	err = r.db.SelectContext(ctx, &orders, query, maxWeight)
+	span.SetAttributes(attribute.Int("delivery.candidate_orders", len(orders)))
 	return orders, err
 }
 
+// 検索で件数を概算する場合に数える上限
+const approximateTotalLimit = 1000
+
 // 注文履歴一覧を取得
-func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
+// 件数は req.Total に従って数える。検索しない場合は user_order_counts から返すので COUNT(*) しない
+func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.ListRequest) (_ []model.Order, _ model.ListPage, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "repository.order", "OrderRepository.ListOrders",
+		attribute.String("search.type", req.Type),
+		attribute.String("list.total_mode", req.Total),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	var whereConditions []string
 	var args []any
 
@@ -181,10 +339,16 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 	args = append(args, userID)
 
 	if req.Search != "" {
-		if req.Type == "prefix" {
+		switch req.Type {
+		case "prefix":
 			whereConditions = append(whereConditions, "p.name LIKE ?")
 			args = append(args, req.Search+"%")
-		} else {
+		case "fulltext":
+			// 商品検索と同じ全文検索インデックスを使うので、商品名に加えて説明文も対象になる
+			searchCond, searchArgs := r.dialect.FullTextMatch([]string{"p.name", "p.description"}, req.Search)
+			whereConditions = append(whereConditions, searchCond)
+			args = append(args, searchArgs...)
+		default:
 			whereConditions = append(whereConditions, "p.name LIKE ?")
 			args = append(args, "%"+req.Search+"%")
 		}
@@ -192,18 +356,6 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 
 	whereClause := strings.Join(whereConditions, " AND ")
 
-	countQuery := fmt.Sprintf(`
-        SELECT COUNT(*)
-        FROM orders o
-        JOIN products p ON o.product_id = p.product_id
-        WHERE %s
-    `, whereClause)
-
-	var total int
-	if err := r.db.GetContext(ctx, &total, countQuery, args...); err != nil {
-		return nil, 0, err
-	}
-
 	var orderByClause string
 	sortOrder := "ASC"
 	if strings.ToUpper(req.SortOrder) == "DESC" {
@@ -225,6 +377,7 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 		orderByClause = fmt.Sprintf("o.order_id %s", sortOrder)
 	}
 
+	// 続きがあるかを知るため 1 件多く読む
 	query := fmt.Sprintf(`
         SELECT o.order_id, o.product_id, p.name as product_name, o.shipped_status, o.created_at, o.arrived_at
         FROM orders o
@@ -234,8 +387,6 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
         LIMIT ? OFFSET ?
     `, whereClause, orderByClause)
 
-	args = append(args, req.PageSize, req.Offset)
-
 	type orderRow struct {
 		OrderID       int          `db:"order_id"`
 		ProductID     int          `db:"product_id"`
@@ -245,8 +396,14 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 		ArrivedAt     sql.NullTime `db:"arrived_at"`
 	}
 	var ordersRaw []orderRow
-	if err := r.db.SelectContext(ctx, &ordersRaw, query, args...); err != nil {
-		return nil, 0, err
+	if err := r.read.SelectContext(ctx, &ordersRaw, query, append(args, req.PageSize+1, req.Offset)...); err != nil {
+		return nil, model.ListPage{}, err
+	}
+
+	var page model.ListPage
+	if len(ordersRaw) > req.PageSize {
+		page.HasMore = true
+		ordersRaw = ordersRaw[:req.PageSize]
 	}
 
 	var orders []model.Order
@@ -261,5 +418,47 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 		})
 	}
 
-	return orders, total, nil
+	if req.Total != model.ListTotalNone {
+		total, approximate, err := r.countListedOrders(ctx, userID, req, whereClause, args, len(orders), page.HasMore)
+		if err != nil {
+			return nil, model.ListPage{}, err
+		}
+		page.Total, page.TotalApproximate = &total, approximate
+		span.AddEvent("orders counted", trace.WithAttributes(
+			attribute.Int("list.total", total),
+			attribute.Bool("list.total_approximate", approximate),
+		))
+	}
+
+	return orders, page, nil
+}
+
+// ListOrders の条件に合う注文を数える
+// 最後のページまで読めていれば数えなくてもわかるので、そのまま返す
+func (r *OrderRepository) countListedOrders(ctx context.Context, userID int, req model.ListRequest, whereClause string, args []any, returned int, hasMore bool) (int, bool, error) {
+	if !hasMore && (returned > 0 || req.Offset == 0) {
+		return req.Offset + returned, false, nil
+	}
+	if req.Search == "" {
+		total, err := r.CountOrders(ctx, userID)
+		return total, false, err
+	}
+
+	from := fmt.Sprintf(`
+        FROM orders o
+        JOIN products p ON o.product_id = p.product_id
+        WHERE %s
+    `, whereClause)
+	var total int
+	if req.Total == model.ListTotalApproximate {
+		query := "SELECT COUNT(*) FROM (SELECT 1 " + from + " LIMIT ?) t"
+		if err := r.read.GetContext(ctx, &total, query, append(args, approximateTotalLimit)...); err != nil {
+			return 0, false, err
+		}
+		return total, total >= approximateTotalLimit, nil
+	}
+	if err := r.read.GetContext(ctx, &total, "SELECT COUNT(*) "+from, args...); err != nil {
+		return 0, false, err
+	}
+	return total, false, nil
 }
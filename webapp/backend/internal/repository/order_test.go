@@ -0,0 +1,377 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 配送待ちキャッシュの 1 行
+type cacheRow struct {
+	OrderID int64 `db:"order_id"`
+	Weight  int   `db:"weight"`
+	Value   int   `db:"value"`
+}
+
+func shippingCache(t *testing.T, store *Store) []cacheRow {
+	t.Helper()
+	var rows []cacheRow
+	if err := store.db.SelectContext(context.Background(), &rows,
+		"SELECT order_id, weight, value FROM shipping_order_cache ORDER BY order_id"); err != nil {
+		t.Fatalf("select shipping_order_cache: %v", err)
+	}
+	return rows
+}
+
+func orderIDs(orders []model.Order) []int64 {
+	var ids []int64
+	for _, o := range orders {
+		ids = append(ids, o.OrderID)
+	}
+	return ids
+}
+
+func TestOrderRepositoryCreate(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+
+	id, err := store.OrderRepo.Create(context.Background(), &model.Order{UserID: 2, ProductID: 3})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if id != "7" {
+		t.Errorf("order id = %q, want %q", id, "7")
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM orders WHERE order_id = 7 AND shipped_status = 'shipping'"); n != 1 {
+		t.Errorf("stored orders = %d, want 1", n)
+	}
+	if rows := shippingCache(t, store); !slices.Contains(rows, cacheRow{7, 1, 800}) {
+		t.Errorf("shipping_order_cache = %+v, want a row for order 7", rows)
+	}
+}
+
+func TestOrderRepositoryBulkCreate(t *testing.T) {
+	tests := []struct {
+		name      string
+		orders    []model.Order
+		wantIDs   []string
+		wantCache []cacheRow
+	}{
+		{
+			name:    "empty",
+			orders:  nil,
+			wantIDs: []string{},
+		},
+		{
+			name: "several orders",
+			orders: []model.Order{
+				{UserID: 1, ProductID: 2},
+				{UserID: 1, ProductID: 2},
+				{UserID: 1, ProductID: 5},
+			},
+			wantIDs:   []string{"7", "8", "9"},
+			wantCache: []cacheRow{{7, 1, 120}, {8, 1, 120}, {9, 3, 950}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newSQLiteStore(t, allFixtures...)
+
+			ids, err := store.OrderRepo.BulkCreate(context.Background(), tt.orders)
+			if err != nil {
+				t.Fatalf("BulkCreate: %v", err)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("order ids = %q, want %q", ids, tt.wantIDs)
+			}
+			rows := shippingCache(t, store)
+			if got := len(rows); got != 4+len(tt.wantCache) {
+				t.Errorf("shipping_order_cache rows = %d, want %d", got, 4+len(tt.wantCache))
+			}
+			for _, want := range tt.wantCache {
+				if !slices.Contains(rows, want) {
+					t.Errorf("shipping_order_cache = %+v, missing %+v", rows, want)
+				}
+			}
+		})
+	}
+}
+
+// orders に行が入るたびに別の注文を割り込ませ、1 つの INSERT 内の採番を飛び飛びにする
+// innodb_autoinc_lock_mode=2 で他のセッションと同時に挿入したときの状態を再現する
+func interleaveOrderIDs(t *testing.T, db *sqlx.DB) {
+	t.Helper()
+	_, err := db.Exec(`
+		CREATE TRIGGER interleave_orders AFTER INSERT ON orders
+		WHEN NEW.shipped_status = 'shipping'
+		BEGIN
+			INSERT INTO orders (user_id, product_id, shipped_status, created_at)
+			VALUES (NEW.user_id, NEW.product_id, 'interleaved', NEW.created_at);
+		END`)
+	if err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+}
+
+func TestOrderRepositoryBulkCreateWithInterleavedIDs(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+	interleaveOrderIDs(t, db)
+
+	ids, err := store.OrderRepo.BulkCreate(context.Background(), []model.Order{
+		{UserID: 2, ProductID: 2},
+		{UserID: 2, ProductID: 3},
+		{UserID: 2, ProductID: 5},
+	})
+	if err != nil {
+		t.Fatalf("BulkCreate: %v", err)
+	}
+	if want := []string{"7", "9", "11"}; !slices.Equal(ids, want) {
+		t.Errorf("order ids = %q, want %q", ids, want)
+	}
+	want := []cacheRow{{1, 2, 300}, {2, 1, 800}, {5, 1, 120}, {6, 5, 1500}, {7, 1, 120}, {9, 1, 800}, {11, 3, 950}}
+	if rows := shippingCache(t, store); !slices.Equal(rows, want) {
+		t.Errorf("shipping_order_cache = %+v, want %+v", rows, want)
+	}
+}
+
+func TestOrderRepositoryBulkCreateConcurrently(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+	interleaveOrderIDs(t, db)
+	ctx := context.Background()
+
+	const (
+		workers = 8
+		batches = 10
+	)
+	type created struct {
+		orders []model.Order
+		ids    []string
+	}
+	results := make([][]created, workers)
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				var orders []model.Order
+				for i := range b%5 + 1 {
+					orders = append(orders, model.Order{UserID: w%2 + 1, ProductID: (w+b+i)%5 + 1})
+				}
+				err := store.ExecTx(ctx, func(txStore *Store) error {
+					ids, err := txStore.OrderRepo.BulkCreate(ctx, orders)
+					if err != nil {
+						return err
+					}
+					results[w] = append(results[w], created{orders, ids})
+					return nil
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("BulkCreate: %v", err)
+	}
+
+	// 返された ID は、渡した注文と同じ順に対応している
+	seen := map[string]bool{}
+	for _, batches := range results {
+		for _, c := range batches {
+			if len(c.ids) != len(c.orders) {
+				t.Fatalf("got %d ids for %d orders", len(c.ids), len(c.orders))
+			}
+			for i, id := range c.ids {
+				if seen[id] {
+					t.Errorf("order id %s returned twice", id)
+				}
+				seen[id] = true
+				var got model.Order
+				if err := db.Get(&got, "SELECT order_id, user_id, product_id, shipped_status FROM orders WHERE order_id = ?", id); err != nil {
+					t.Fatalf("find order %s: %v", id, err)
+				}
+				want := c.orders[i]
+				if got.UserID != want.UserID || got.ProductID != want.ProductID || got.ShippedStatus != "shipping" {
+					t.Errorf("order %s = %+v, want user %d, product %d", id, got, want.UserID, want.ProductID)
+				}
+			}
+		}
+	}
+
+	// キャッシュは配送待ちの注文とちょうど一致し、重量と価値は商品と同じ
+	shipping := countRows(t, db, "SELECT COUNT(*) FROM orders WHERE shipped_status = 'shipping'")
+	if want := 4 + len(seen); shipping != want {
+		t.Errorf("shipping orders = %d, want %d", shipping, want)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM shipping_order_cache"); n != shipping {
+		t.Errorf("shipping_order_cache rows = %d, want %d", n, shipping)
+	}
+	mismatched := countRows(t, db, `
+		SELECT COUNT(*)
+		FROM orders o
+		JOIN products p ON o.product_id = p.product_id
+		LEFT JOIN shipping_order_cache c ON c.order_id = o.order_id
+		WHERE o.shipped_status = 'shipping'
+		  AND (c.order_id IS NULL OR c.weight != p.weight OR c.value != p.value)
+	`)
+	if mismatched != 0 {
+		t.Errorf("%d shipping orders are missing from or stale in shipping_order_cache", mismatched)
+	}
+}
+
+func TestOrderRepositoryUpdateStatuses(t *testing.T) {
+	tests := []struct {
+		name      string
+		orderIDs  []int64
+		status    string
+		wantCache []int64
+	}{
+		{"delivering removes from cache", []int64{1, 2}, "delivering", []int64{5, 6}},
+		{"shipping adds to cache", []int64{3}, "shipping", []int64{1, 2, 3, 5, 6}},
+		{"no orders", nil, "delivering", []int64{1, 2, 5, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, db := newSQLiteStore(t, allFixtures...)
+
+			if err := store.OrderRepo.UpdateStatuses(context.Background(), tt.orderIDs, tt.status); err != nil {
+				t.Fatalf("UpdateStatuses: %v", err)
+			}
+			for _, id := range tt.orderIDs {
+				if n := countRows(t, db, "SELECT COUNT(*) FROM orders WHERE order_id = ? AND shipped_status = ?", id, tt.status); n != 1 {
+					t.Errorf("order %d was not updated to %q", id, tt.status)
+				}
+			}
+			var cached []int64
+			for _, row := range shippingCache(t, store) {
+				cached = append(cached, row.OrderID)
+			}
+			if !slices.Equal(cached, tt.wantCache) {
+				t.Errorf("cached orders = %v, want %v", cached, tt.wantCache)
+			}
+		})
+	}
+}
+
+func TestOrderRepositorySyncShippingCacheByProduct(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+
+	if _, err := db.Exec("UPDATE products SET weight = 9, value = 999 WHERE product_id = 4"); err != nil {
+		t.Fatalf("update product: %v", err)
+	}
+	if err := store.OrderRepo.SyncShippingCacheByProduct(context.Background(), 4); err != nil {
+		t.Fatalf("SyncShippingCacheByProduct: %v", err)
+	}
+	// 配送待ちでない注文 3 はキャッシュに入らない
+	want := []cacheRow{{1, 2, 300}, {2, 1, 800}, {5, 1, 120}, {6, 9, 999}}
+	if rows := shippingCache(t, store); !slices.Equal(rows, want) {
+		t.Errorf("shipping_order_cache = %+v, want %+v", rows, want)
+	}
+}
+
+func TestOrderRepositoryGetShippingOrders(t *testing.T) {
+	store, _ := newSQLiteStore(t, allFixtures...)
+
+	orders, err := store.OrderRepo.GetShippingOrders(context.Background())
+	if err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	ids := orderIDs(orders)
+	slices.Sort(ids)
+	if want := []int64{1, 2, 5, 6}; !slices.Equal(ids, want) {
+		t.Errorf("order ids = %v, want %v", ids, want)
+	}
+}
+
+func TestOrderRepositoryGetShippingOrdersOptimized(t *testing.T) {
+	store, _ := newSQLiteStore(t, allFixtures...)
+
+	tests := []struct {
+		name      string
+		maxWeight int
+		want      []int64
+	}{
+		{"light orders only", 1, []int64{2, 5}},
+		{"sorted by value", 5, []int64{6, 2, 1, 5}},
+		{"nothing fits", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := store.OrderRepo.GetShippingOrdersOptimized(context.Background(), tt.maxWeight)
+			if err != nil {
+				t.Fatalf("GetShippingOrdersOptimized: %v", err)
+			}
+			if ids := orderIDs(orders); !slices.Equal(ids, tt.want) {
+				t.Errorf("order ids = %v, want %v", ids, tt.want)
+			}
+			for _, o := range orders {
+				if o.Weight > tt.maxWeight {
+					t.Errorf("order %d weighs %d, over capacity %d", o.OrderID, o.Weight, tt.maxWeight)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderRepositoryListOrders(t *testing.T) {
+	store, _ := newSQLiteStore(t, allFixtures...)
+
+	tests := []struct {
+		name      string
+		userID    int
+		req       model.ListRequest
+		wantIDs   []int64
+		wantTotal int
+	}{
+		{"all", 1, model.ListRequest{PageSize: 10}, []int64{1, 2, 3, 4}, 4},
+		{"partial match", 1, model.ListRequest{Search: "Apple", Type: "partial", PageSize: 10}, []int64{1, 4}, 2},
+		{"prefix match", 1, model.ListRequest{Search: "Pie", Type: "prefix", PageSize: 10}, nil, 0},
+		{"partial match inside name", 1, model.ListRequest{Search: "Pie", Type: "partial", PageSize: 10}, []int64{4}, 1},
+		{"fulltext matches description", 1, model.ListRequest{Search: "apples", Type: "fulltext", PageSize: 10}, []int64{4}, 1},
+		{"fulltext matches name", 1, model.ListRequest{Search: "Durian", Type: "fulltext", PageSize: 10}, []int64{3}, 1},
+		{"partial ignores description", 1, model.ListRequest{Search: "apples", Type: "partial", PageSize: 10}, nil, 0},
+		{"sort by product name", 1, model.ListRequest{SortField: "product_name", SortOrder: "desc", PageSize: 10}, []int64{3, 2, 4, 1}, 4},
+		{"paging", 1, model.ListRequest{PageSize: 2, Offset: 2}, []int64{3, 4}, 4},
+		{"other user", 2, model.ListRequest{PageSize: 10}, []int64{5, 6}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, page, err := store.OrderRepo.ListOrders(context.Background(), tt.userID, tt.req)
+			if err != nil {
+				t.Fatalf("ListOrders: %v", err)
+			}
+			if ids := orderIDs(orders); !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("order ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if page.Total == nil || *page.Total != tt.wantTotal {
+				t.Errorf("total = %v, want %d", page.Total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestOrderRepositoryListOrdersScansTimes(t *testing.T) {
+	store, _ := newSQLiteStore(t, allFixtures...)
+
+	orders, _, err := store.OrderRepo.ListOrders(context.Background(), 1, model.ListRequest{PageSize: 10})
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	for _, o := range orders {
+		if o.CreatedAt.IsZero() {
+			t.Errorf("order %d has no created_at", o.OrderID)
+		}
+		if completed := o.ShippedStatus == "completed"; o.ArrivedAt.Valid != completed {
+			t.Errorf("order %d (%s) arrived_at valid = %v", o.OrderID, o.ShippedStatus, o.ArrivedAt.Valid)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// user_order_counts の 1 行を表すキー
+type orderCountKey struct {
+	UserID int    `db:"user_id"`
+	Status string `db:"shipped_status"`
+}
+
+// user_order_counts に足す数
+// 注文の書き込みと同じトランザクションで適用し、orders と食い違わないようにする
+type orderCountDeltas map[orderCountKey]int
+
+func (d orderCountDeltas) add(userID int, status string, n int) {
+	d[orderCountKey{UserID: userID, Status: status}] += n
+}
+
+// 数えた注文をすべて status に移したときの増減
+func (d orderCountDeltas) movedTo(status string) orderCountDeltas {
+	moved := make(orderCountDeltas, len(d)*2)
+	for k, n := range d {
+		moved.add(k.UserID, k.Status, -n)
+		moved.add(k.UserID, status, n)
+	}
+	return moved
+}
+
+// 数えた注文がすべて消えたときの増減
+func (d orderCountDeltas) removed() orderCountDeltas {
+	removed := make(orderCountDeltas, len(d))
+	for k, n := range d {
+		removed[k] = -n
+	}
+	return removed
+}
+
+// where に合う注文を数え、トランザクションの終わりまで行をロックする
+// ステータスを変える前に呼び、変更前のステータスで数えた数を差し引くのに使う
+func countOrdersForUpdate(ctx context.Context, db DBTX, dialect Dialect, where string, args ...any) (orderCountDeltas, error) {
+	query, args, err := sqlx.In("SELECT user_id, shipped_status FROM orders WHERE "+where+" "+dialect.ForUpdate(), args...)
+	if err != nil {
+		return nil, err
+	}
+	var rows []orderCountKey
+	if err := db.SelectContext(ctx, &rows, db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	counts := make(orderCountDeltas)
+	for _, row := range rows {
+		counts[row]++
+	}
+	return counts, nil
+}
+
+// deltas を user_order_counts に足す
+// 同じ行を更新するトランザクション同士がデッドロックしないよう、キーの順に書き込む
+func applyOrderCounts(ctx context.Context, db DBTX, dialect Dialect, deltas orderCountDeltas) error {
+	keys := slices.SortedFunc(maps.Keys(deltas), func(a, b orderCountKey) int {
+		if c := cmp.Compare(a.UserID, b.UserID); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Status, b.Status)
+	})
+
+	valueStrings := make([]string, 0, len(keys))
+	args := make([]any, 0, len(keys)*3)
+	for _, k := range keys {
+		if deltas[k] == 0 {
+			continue
+		}
+		valueStrings = append(valueStrings, "(?, ?, ?)")
+		args = append(args, k.UserID, k.Status, deltas[k])
+	}
+	if len(valueStrings) == 0 {
+		return nil
+	}
+	query := "INSERT INTO user_order_counts (user_id, shipped_status, order_count) VALUES " +
+		strings.Join(valueStrings, ", ") + " " +
+		dialect.OnConflictAdd([]string{"user_id", "shipped_status"}, "order_count")
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ユーザーの注文数を user_order_counts から返す
+func (r *OrderRepository) CountOrders(ctx context.Context, userID int) (int, error) {
+	var total int
+	err := r.read.GetContext(ctx, &total, "SELECT COALESCE(SUM(order_count), 0) FROM user_order_counts WHERE user_id = ?", userID)
+	return total, err
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"maps"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// user_order_counts が orders を数え直した結果と一致するか確かめる
+func checkOrderCounts(t *testing.T, db *sqlx.DB) {
+	t.Helper()
+	type countRow struct {
+		orderCountKey
+		Count int `db:"order_count"`
+	}
+	read := func(query string) map[orderCountKey]int {
+		t.Helper()
+		var rows []countRow
+		if err := db.Select(&rows, query); err != nil {
+			t.Fatalf("select counts: %v", err)
+		}
+		counts := make(map[orderCountKey]int)
+		for _, r := range rows {
+			if r.Count != 0 {
+				counts[r.orderCountKey] = r.Count
+			}
+		}
+		return counts
+	}
+	got := read("SELECT user_id, shipped_status, order_count FROM user_order_counts")
+	want := read("SELECT user_id, shipped_status, COUNT(*) AS order_count FROM orders GROUP BY user_id, shipped_status")
+	if !maps.Equal(got, want) {
+		t.Errorf("user_order_counts = %v, want %v", got, want)
+	}
+}
+
+func TestOrderCountsFollowWrites(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+	ctx := context.Background()
+	checkOrderCounts(t, db)
+
+	steps := []struct {
+		name string
+		fn   func(txStore *Store) error
+	}{
+		{"create", func(txStore *Store) error {
+			_, err := txStore.OrderRepo.Create(ctx, &model.Order{UserID: 2, ProductID: 3})
+			return err
+		}},
+		{"bulk create", func(txStore *Store) error {
+			_, err := txStore.OrderRepo.BulkCreate(ctx, []model.Order{{UserID: 1, ProductID: 2}, {UserID: 2, ProductID: 2}, {UserID: 2, ProductID: 5}})
+			return err
+		}},
+		{"update statuses", func(txStore *Store) error {
+			// 既に completed の注文 4 は数え直さない
+			return txStore.OrderRepo.UpdateStatuses(ctx, []int64{1, 3, 4, 5}, "completed")
+		}},
+		{"back to shipping", func(txStore *Store) error {
+			return txStore.OrderRepo.UpdateStatuses(ctx, []int64{3}, "shipping")
+		}},
+		{"claim", func(txStore *Store) error {
+			// 注文 1 は配送待ちではないので数えない
+			_, err := txStore.OrderRepo.ClaimShippingOrders(ctx, []int64{1, 2, 6})
+			return err
+		}},
+		{"delete product", func(txStore *Store) error {
+			return txStore.ProductRepo.Delete(ctx, 4)
+		}},
+	}
+	for _, step := range steps {
+		if err := store.ExecTx(ctx, step.fn); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		checkOrderCounts(t, db)
+	}
+}
+
+func TestOrderRepositoryListOrdersTotals(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+	ctx := context.Background()
+
+	// ユーザー 2 に Apple Pie (商品 5) の注文を上限を超えるまで追加する
+	var orders []model.Order
+	for range approximateTotalLimit + 10 {
+		orders = append(orders, model.Order{UserID: 2, ProductID: 5})
+	}
+	if _, err := store.OrderRepo.BulkCreate(ctx, orders); err != nil {
+		t.Fatalf("BulkCreate: %v", err)
+	}
+	checkOrderCounts(t, db)
+
+	tests := []struct {
+		name            string
+		userID          int
+		req             model.ListRequest
+		wantTotal       int
+		wantApproximate bool
+		wantNoTotal     bool
+		wantHasMore     bool
+	}{
+		{"counter", 2, model.ListRequest{PageSize: 10}, approximateTotalLimit + 12, false, false, true},
+		{"exact search", 2, model.ListRequest{Search: "Apple", PageSize: 10}, approximateTotalLimit + 10, false, false, true},
+		{"approximate search", 2, model.ListRequest{Search: "Apple", PageSize: 10, Total: model.ListTotalApproximate}, approximateTotalLimit, true, false, true},
+		{"approximate without search", 2, model.ListRequest{PageSize: 10, Total: model.ListTotalApproximate}, approximateTotalLimit + 12, false, false, true},
+		{"no total", 2, model.ListRequest{Search: "Apple", PageSize: 10, Total: model.ListTotalNone}, 0, false, true, true},
+		{"last page", 1, model.ListRequest{PageSize: 3, Offset: 3}, 4, false, false, false},
+		{"past the end", 1, model.ListRequest{PageSize: 3, Offset: 9}, 4, false, false, false},
+		{"small search", 1, model.ListRequest{Search: "Apple", PageSize: 1, Total: model.ListTotalApproximate}, 2, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, page, err := store.OrderRepo.ListOrders(ctx, tt.userID, tt.req)
+			if err != nil {
+				t.Fatalf("ListOrders: %v", err)
+			}
+			if page.HasMore != tt.wantHasMore {
+				t.Errorf("has more = %v, want %v", page.HasMore, tt.wantHasMore)
+			}
+			if tt.wantNoTotal {
+				if page.Total != nil {
+					t.Errorf("total = %d, want none", *page.Total)
+				}
+				return
+			}
+			if page.Total == nil || *page.Total != tt.wantTotal || page.TotalApproximate != tt.wantApproximate {
+				t.Errorf("total = %v (approximate %v), want %d (approximate %v)", page.Total, page.TotalApproximate, tt.wantTotal, tt.wantApproximate)
+			}
+		})
+	}
+}
+
+// 検索しない一覧の件数は orders を数えず user_order_counts から返す
+func TestOrderRepositoryListOrdersUsesCounters(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+	if _, err := db.Exec("UPDATE user_order_counts SET order_count = 40 WHERE user_id = 1 AND shipped_status = 'shipping'"); err != nil {
+		t.Fatalf("update counts: %v", err)
+	}
+
+	_, page, err := store.OrderRepo.ListOrders(context.Background(), 1, model.ListRequest{PageSize: 2})
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if page.Total == nil || *page.Total != 42 {
+		t.Errorf("total = %v, want 42", page.Total)
+	}
+}
@@ -2,52 +2,183 @@ package repository
 
 import (
 	"backend/internal/model"
+	"backend/internal/telemetry"
 	"context"
+	"database/sql"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // DB へのアクセスをまとめて面倒を見る層。UseCase からはこのパッケージを経由して DB とやり取りする。
 type ProductRepository struct {
 	db DBTX
+	// 一覧・詳細の読み取り用。レプリカに振り分けられることがある
+	read    DBTX
+	dialect Dialect
+	// 商品の削除で消えた注文を取り除く
+	index *ShippingIndex
+	// 一覧のキャッシュ。nil ならキャッシュしない
+	catalog *productCatalog
+	hooks   *commitHooks
 }
 
 // NewProductRepository はリポジトリを初期化し、呼び出し側から渡された DB インターフェースを保持する。
-func NewProductRepository(db DBTX) *ProductRepository {
-	return &ProductRepository{db: db}
+func NewProductRepository(db, read DBTX, dialect Dialect, index *ShippingIndex) *ProductRepository {
+	return &ProductRepository{db: db, read: read, dialect: dialect, index: index}
 }
 
 // 条件やページ番号を受け取り、商品一覧と件数を返す
-func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req model.ListRequest) ([]model.Product, int, error) {
-	var products []model.Product
+// キャッシュがあれば条件に合う全商品をキャッシュから読み、ページ分けだけを行う
+func (r *ProductRepository) ListProducts(ctx context.Context, userID int, req model.ListRequest) (_ []model.Product, _ int, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "repository.product", "ProductRepository.ListProducts",
+		attribute.Bool("search.fulltext", req.Search != ""),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	// トランザクション内や強い一貫性を求められた読み取りではキャッシュを使わない
+	if r.catalog != nil && r.hooks == nil && !usePrimary(ctx) {
+		// 書き込み直後に古い一覧を詰め直さないよう、レプリカではなくプライマリから読む
+		products, err := r.catalog.get(ctx, req, func(ctx context.Context) ([]model.Product, error) {
+			return r.selectProducts(ctx, r.db, req, false)
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		span.SetAttributes(attribute.Bool("cache.used", true))
+		total := len(products)
+		page := products[min(req.Offset, total):min(req.Offset+req.PageSize, total)]
+		return slices.Clone(page), total, nil
+	}
+
+	products, err := r.selectProducts(ctx, r.read, req, true)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	baseQuery := `
+	countQuery := "SELECT COUNT(*) FROM products"
+	var countArgs []any
+	if req.Search != "" {
+		var searchCond string
+		searchCond, countArgs = r.dialect.FullTextMatch([]string{"name", "description"}, req.Search)
+		countQuery += " WHERE " + searchCond
+	}
+	var total int
+	if err := r.read.GetContext(ctx, &total, countQuery, countArgs...); err != nil {
+		return nil, 0, err
+	}
+
+	return products, total, nil
+}
+
+// 条件に合う商品を並べて返す。paged ならそのページだけを返す
+func (r *ProductRepository) selectProducts(ctx context.Context, db DBTX, req model.ListRequest, paged bool) ([]model.Product, error) {
+	query := `
 		SELECT product_id, name, value, weight, image, description
 		FROM products
 	`
-	args := []interface{}{}
-
+	var args []any
 	if req.Search != "" {
-		baseQuery += " WHERE MATCH(name, description) AGAINST(? IN BOOLEAN MODE)"
-		args = append(args, req.Search)
+		searchCond, searchArgs := r.dialect.FullTextMatch([]string{"name", "description"}, req.Search)
+		query += " WHERE " + searchCond
+		args = append(args, searchArgs...)
+	}
+	query += " ORDER BY " + req.SortField + " " + req.SortOrder + ", product_id ASC"
+	if paged {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, req.PageSize, req.Offset)
 	}
 
-	baseQuery += " ORDER BY " + req.SortField + " " + req.SortOrder + ", product_id ASC LIMIT ? OFFSET ?"
-	args = append(args, req.PageSize, req.Offset)
+	var products []model.Product
+	if err := db.SelectContext(ctx, &products, query, args...); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
 
-	countQuery := "SELECT COUNT(*) FROM products"
-	countArgs := []interface{}{}
-	if req.Search != "" {
-		countQuery += " WHERE MATCH(name, description) AGAINST(? IN BOOLEAN MODE)"
-		countArgs = append(countArgs, req.Search)
+// 一覧キャッシュの統計。キャッシュしていなければゼロ値を返す
+func (r *ProductRepository) CatalogStats() CatalogStats {
+	if r.catalog == nil {
+		return CatalogStats{}
 	}
+	return r.catalog.stats()
+}
 
-	if err := r.db.SelectContext(ctx, &products, baseQuery, args...); err != nil {
-		return nil, 0, err
+// 商品の書き込みがコミットされたら一覧のキャッシュを捨てる
+func (r *ProductRepository) invalidateCatalog() {
+	if r.catalog == nil {
+		return
 	}
+	r.hooks.add(r.catalog.invalidate)
+}
 
-	var total int
-	if err := r.db.GetContext(ctx, &total, countQuery, countArgs...); err != nil {
-		return nil, 0, err
+// 商品IDから商品を取得
+func (r *ProductRepository) FindByID(ctx context.Context, productID int) (*model.Product, error) {
+	var product model.Product
+	query := "SELECT product_id, name, value, weight, image, description FROM products WHERE product_id = ?"
+	if err := r.read.GetContext(ctx, &product, query, productID); err != nil {
+		return nil, err
 	}
+	return &product, nil
+}
 
-	return products, total, nil
+// 商品を作成し、生成された商品IDを返す
+func (r *ProductRepository) Create(ctx context.Context, product *model.Product) (int, error) {
+	query := "INSERT INTO products (name, value, weight, image, description) VALUES (?, ?, ?, ?, ?)"
+	result, err := r.db.ExecContext(ctx, query, product.Name, product.Value, product.Weight, product.Image, product.Description)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	r.invalidateCatalog()
+	return int(id), nil
+}
+
+// 商品を更新する
+// MySQL は値が変わらない UPDATE を 0 件と数えるため、存在確認は呼び出し側で FindByID を使って行う
+func (r *ProductRepository) Update(ctx context.Context, product *model.Product) error {
+	query := "UPDATE products SET name = ?, value = ?, weight = ?, image = ?, description = ? WHERE product_id = ?"
+	if _, err := r.db.ExecContext(ctx, query, product.Name, product.Value, product.Weight, product.Image, product.Description, product.ProductID); err != nil {
+		return err
+	}
+	r.invalidateCatalog()
+	return nil
+}
+
+// 商品の画像パスだけを更新する
+func (r *ProductRepository) UpdateImage(ctx context.Context, productID int, image string) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE products SET image = ? WHERE product_id = ?", image, productID); err != nil {
+		return err
+	}
+	r.invalidateCatalog()
+	return nil
+}
+
+// 商品を削除する。対象が存在しない場合は sql.ErrNoRows を返す
+// 注文と shipping_order_cache は外部キーの ON DELETE CASCADE で消える。消える注文の数は user_order_counts から差し引く
+func (r *ProductRepository) Delete(ctx context.Context, productID int) error {
+	counts, err := countOrdersForUpdate(ctx, r.db, r.dialect, "product_id = ?", productID)
+	if err != nil {
+		return err
+	}
+	if err := applyOrderCounts(ctx, r.db, r.dialect, counts.removed()); err != nil {
+		return err
+	}
+	result, err := r.db.ExecContext(ctx, "DELETE FROM products WHERE product_id = ?", productID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	r.hooks.add(func() { r.index.RemoveProduct(productID) })
+	r.invalidateCatalog()
+	return nil
 }
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestProductRepositoryListProducts(t *testing.T) {
+	store, _ := newSQLiteStore(t, "products")
+
+	tests := []struct {
+		name      string
+		req       model.ListRequest
+		wantIDs   []int
+		wantTotal int
+	}{
+		{
+			name:      "first page",
+			req:       model.ListRequest{SortField: "product_id", SortOrder: "ASC", PageSize: 2},
+			wantIDs:   []int{1, 2},
+			wantTotal: 5,
+		},
+		{
+			name:      "offset",
+			req:       model.ListRequest{SortField: "product_id", SortOrder: "ASC", PageSize: 2, Offset: 2},
+			wantIDs:   []int{3, 4},
+			wantTotal: 5,
+		},
+		{
+			name:      "sort by value",
+			req:       model.ListRequest{SortField: "value", SortOrder: "DESC", PageSize: 10},
+			wantIDs:   []int{4, 5, 3, 1, 2},
+			wantTotal: 5,
+		},
+		{
+			name:      "search",
+			req:       model.ListRequest{Search: "Apple", SortField: "product_id", SortOrder: "ASC", PageSize: 10},
+			wantIDs:   []int{1, 5},
+			wantTotal: 2,
+		},
+		{
+			name:      "no match",
+			req:       model.ListRequest{Search: "zzz", SortField: "product_id", SortOrder: "ASC", PageSize: 10},
+			wantIDs:   nil,
+			wantTotal: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			products, total, err := store.ProductRepo.ListProducts(context.Background(), 1, tt.req)
+			if err != nil {
+				t.Fatalf("ListProducts: %v", err)
+			}
+			var ids []int
+			for _, p := range products {
+				ids = append(ids, p.ProductID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("product ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryFindByID(t *testing.T) {
+	store, _ := newSQLiteStore(t, "products")
+
+	tests := []struct {
+		name      string
+		productID int
+		wantName  string
+		wantErr   error
+	}{
+		{"found", 3, "Cherry", nil},
+		{"missing", 99, "", sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product, err := store.ProductRepo.FindByID(context.Background(), tt.productID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (product.ProductID != tt.productID || product.Name != tt.wantName) {
+				t.Errorf("product = %+v, want id %d, name %q", product, tt.productID, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestProductRepositoryCreateAndUpdate(t *testing.T) {
+	store, _ := newSQLiteStore(t, "products")
+	ctx := context.Background()
+
+	product := model.Product{Name: "Fig", Value: 400, Weight: 1, Image: "fig.png", Description: "Sweet fig"}
+	id, err := store.ProductRepo.Create(ctx, &product)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if id != 6 {
+		t.Errorf("product id = %d, want 6", id)
+	}
+
+	product.ProductID = id
+	product.Value = 450
+	product.Weight = 2
+	if err := store.ProductRepo.Update(ctx, &product); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := store.ProductRepo.UpdateImage(ctx, id, "fig2.png"); err != nil {
+		t.Fatalf("UpdateImage: %v", err)
+	}
+
+	got, err := store.ProductRepo.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	want := product
+	want.Image = "fig2.png"
+	if *got != want {
+		t.Errorf("product = %+v, want %+v", *got, want)
+	}
+}
+
+func TestProductRepositoryDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		productID int
+		wantErr   error
+		// 削除後に残る注文と配送待ちキャッシュの件数
+		wantOrders int
+		wantCache  int
+	}{
+		{"cascades to orders", 4, nil, 4, 3},
+		{"missing", 99, sql.ErrNoRows, 6, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, db := newSQLiteStore(t, allFixtures...)
+
+			err := store.ProductRepo.Delete(context.Background(), tt.productID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if n := countRows(t, db, "SELECT COUNT(*) FROM orders"); n != tt.wantOrders {
+				t.Errorf("orders = %d, want %d", n, tt.wantOrders)
+			}
+			if n := countRows(t, db, "SELECT COUNT(*) FROM shipping_order_cache"); n != tt.wantCache {
+				t.Errorf("shipping_order_cache rows = %d, want %d", n, tt.wantCache)
+			}
+		})
+	}
+}
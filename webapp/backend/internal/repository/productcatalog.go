@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// 商品一覧のキャッシュ
+// 検索語・並び順ごとに、条件に合う全商品を DB の並び順のまま持ち、ページ分けと件数はメモリで行う
+// 絞り込みのない一覧は常に持ち、検索結果は LRU で maxSearches 件まで持つ
+// 商品の書き込みがコミットされると全て捨てる
+type productCatalog struct {
+	ttl         time.Duration
+	maxSearches int
+
+	mu       sync.Mutex
+	listings map[string]*catalogEntry
+	searches map[string]*list.Element
+	lru      *list.List
+	// 捨てた回数。読み込み中に捨てられた結果をキャッシュしないために使う
+	generation uint64
+
+	loads         singleflight.Group
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+type catalogEntry struct {
+	key       string
+	products  []model.Product
+	expiresAt time.Time
+}
+
+// 商品一覧キャッシュの累計と現在の件数
+type CatalogStats struct {
+	// キャッシュが設定されているか
+	Enabled       bool   `json:"enabled"`
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	// キャッシュしている絞り込みなしの一覧と検索結果の数
+	Listings int `json:"listings"`
+	Searches int `json:"searches"`
+}
+
+func newProductCatalog(ttl time.Duration, maxSearches int) *productCatalog {
+	return &productCatalog{
+		ttl:         ttl,
+		maxSearches: maxSearches,
+		listings:    make(map[string]*catalogEntry),
+		searches:    make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+func catalogKey(req model.ListRequest) string {
+	return req.Search + "\x00" + req.SortField + "\x00" + strings.ToUpper(req.SortOrder)
+}
+
+// req に合う全商品を返す。キャッシュになければ load で読み込む
+// 同じ条件の読み込みが重なった場合は 1 回にまとめる
+func (c *productCatalog) get(ctx context.Context, req model.ListRequest, load func(context.Context) ([]model.Product, error)) ([]model.Product, error) {
+	key := catalogKey(req)
+	search := req.Search != ""
+
+	c.mu.Lock()
+	if e := c.lookup(key, search); e != nil {
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return e.products, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	// 捨てる前に始まった読み込みには相乗りしない
+	v, err, _ := c.loads.Do(strconv.FormatUint(generation, 10)+"\x00"+key, func() (any, error) {
+		// 呼び出し元のキャンセルで、待っている他のリクエストまで失敗させない
+		products, err := load(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.generation == generation {
+			c.store(&catalogEntry{key: key, products: products, expiresAt: time.Now().Add(c.ttl)}, search)
+		}
+		return products, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]model.Product), nil
+}
+
+// mu を持った状態で呼ぶ
+func (c *productCatalog) lookup(key string, search bool) *catalogEntry {
+	now := time.Now()
+	if !search {
+		e := c.listings[key]
+		if e == nil || now.After(e.expiresAt) {
+			return nil
+		}
+		return e
+	}
+	el, ok := c.searches[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*catalogEntry)
+	if now.After(e.expiresAt) {
+		c.lru.Remove(el)
+		delete(c.searches, key)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+// mu を持った状態で呼ぶ
+func (c *productCatalog) store(e *catalogEntry, search bool) {
+	if !search {
+		c.listings[e.key] = e
+		return
+	}
+	if el, ok := c.searches[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.searches[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.maxSearches {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.searches, oldest.Value.(*catalogEntry).key)
+		c.evictions.Add(1)
+	}
+}
+
+// キャッシュを全て捨てる
+func (c *productCatalog) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	clear(c.listings)
+	clear(c.searches)
+	c.lru.Init()
+	c.invalidations.Add(1)
+}
+
+func (c *productCatalog) stats() CatalogStats {
+	c.mu.Lock()
+	listings, searches := len(c.listings), len(c.searches)
+	c.mu.Unlock()
+	return CatalogStats{
+		Enabled:       true,
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Listings:      listings,
+		Searches:      searches,
+	}
+}
@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"backend/internal/repository/sqlitetest"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// 商品一覧のキャッシュを有効にした SQLite の Store を作る
+func newCatalogStore(t *testing.T, ttl time.Duration, maxSearches int) *Store {
+	t.Helper()
+	db := sqlitetest.OpenDB(t)
+	sqlitetest.LoadFixtures(t, db, "products")
+	store := NewStore(db, nil, slog.New(slog.NewTextHandler(io.Discard, nil)), WithDialect(SQLite), WithProductCatalog(ttl, maxSearches))
+	t.Cleanup(store.Close)
+	return store
+}
+
+func listIDs(t *testing.T, store *Store, ctx context.Context, req model.ListRequest) ([]int, int) {
+	t.Helper()
+	products, total, err := store.ProductRepo.ListProducts(ctx, 1, req)
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	var ids []int
+	for _, p := range products {
+		ids = append(ids, p.ProductID)
+	}
+	return ids, total
+}
+
+func TestProductCatalogPaginatesLikeSQL(t *testing.T) {
+	cached := newCatalogStore(t, time.Minute, 8)
+	uncached, _ := newSQLiteStore(t, "products")
+
+	reqs := []model.ListRequest{
+		{SortField: "product_id", SortOrder: "ASC", PageSize: 2},
+		{SortField: "product_id", SortOrder: "ASC", PageSize: 2, Offset: 4},
+		{SortField: "product_id", SortOrder: "ASC", PageSize: 2, Offset: 10},
+		{SortField: "value", SortOrder: "DESC", PageSize: 3, Offset: 1},
+		{SortField: "name", SortOrder: "ASC", PageSize: 10},
+		{Search: "Apple", SortField: "product_id", SortOrder: "ASC", PageSize: 10},
+		{Search: "zzz", SortField: "product_id", SortOrder: "ASC", PageSize: 10},
+	}
+	for _, req := range reqs {
+		gotIDs, gotTotal := listIDs(t, cached, context.Background(), req)
+		wantIDs, wantTotal := listIDs(t, uncached, context.Background(), req)
+		if !slices.Equal(gotIDs, wantIDs) || gotTotal != wantTotal {
+			t.Errorf("%+v: got %v (total %d), want %v (total %d)", req, gotIDs, gotTotal, wantIDs, wantTotal)
+		}
+	}
+
+	// 同じ条件はページが違ってもキャッシュから返す
+	stats := cached.ProductRepo.CatalogStats()
+	if stats.Misses != 5 || stats.Hits != 2 {
+		t.Errorf("hits/misses = %d/%d, want 2/5", stats.Hits, stats.Misses)
+	}
+	if stats.Listings != 3 || stats.Searches != 2 {
+		t.Errorf("listings/searches = %d/%d, want 3/2", stats.Listings, stats.Searches)
+	}
+}
+
+func TestProductCatalogInvalidatedByWrites(t *testing.T) {
+	store := newCatalogStore(t, time.Minute, 8)
+	ctx := context.Background()
+	req := model.ListRequest{SortField: "product_id", SortOrder: "ASC", PageSize: 10}
+
+	if _, total := listIDs(t, store, ctx, req); total != 5 {
+		t.Fatalf("total = %d, want 5", total)
+	}
+
+	product := model.Product{Name: "Fig", Value: 400, Weight: 1, Image: "fig.png", Description: "Sweet fig"}
+	id, err := store.ProductRepo.Create(ctx, &product)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if ids, _ := listIDs(t, store, ctx, req); !slices.Contains(ids, id) {
+		t.Errorf("created product %d not listed: %v", id, ids)
+	}
+
+	// ロールバックした書き込みではキャッシュを捨てない
+	errRollback := errors.New("rollback")
+	err = store.ExecTx(ctx, func(txStore *Store) error {
+		if err := txStore.ProductRepo.Delete(ctx, id); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("ExecTx error = %v, want %v", err, errRollback)
+	}
+	if got := store.ProductRepo.CatalogStats().Invalidations; got != 1 {
+		t.Errorf("invalidations after rollback = %d, want 1", got)
+	}
+
+	// コミットした書き込みはコミット後に捨てる
+	err = store.ExecTx(ctx, func(txStore *Store) error {
+		if err := txStore.ProductRepo.Delete(ctx, id); err != nil {
+			return err
+		}
+		if got := store.ProductRepo.CatalogStats().Invalidations; got != 1 {
+			t.Errorf("invalidations before commit = %d, want 1", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExecTx: %v", err)
+	}
+	if ids, _ := listIDs(t, store, ctx, req); slices.Contains(ids, id) {
+		t.Errorf("deleted product %d still listed: %v", id, ids)
+	}
+	if got := store.ProductRepo.CatalogStats().Invalidations; got != 2 {
+		t.Errorf("invalidations = %d, want 2", got)
+	}
+}
+
+func TestProductCatalogBypassedForPrimaryReads(t *testing.T) {
+	store := newCatalogStore(t, time.Minute, 8)
+	req := model.ListRequest{SortField: "product_id", SortOrder: "ASC", PageSize: 10}
+
+	listIDs(t, store, WithPrimary(context.Background()), req)
+	if stats := store.ProductRepo.CatalogStats(); stats.Hits+stats.Misses != 0 || stats.Listings != 0 {
+		t.Errorf("stats = %+v, want cache untouched", stats)
+	}
+}
+
+func TestProductCatalogExpires(t *testing.T) {
+	c := newProductCatalog(10*time.Millisecond, 8)
+	req := model.ListRequest{SortField: "product_id", SortOrder: "ASC"}
+	loads := 0
+	load := func(context.Context) ([]model.Product, error) {
+		loads++
+		return []model.Product{{ProductID: loads}}, nil
+	}
+
+	for range 2 {
+		if _, err := c.get(context.Background(), req, load); err != nil {
+			t.Fatalf("get: %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("loads before expiry = %d, want 1", loads)
+	}
+	time.Sleep(20 * time.Millisecond)
+	products, err := c.get(context.Background(), req, load)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if loads != 2 || products[0].ProductID != 2 {
+		t.Errorf("loads = %d, product = %d; want a reload after expiry", loads, products[0].ProductID)
+	}
+}
+
+func TestProductCatalogEvictsLeastRecentlyUsedSearches(t *testing.T) {
+	c := newProductCatalog(time.Minute, 2)
+	load := func(context.Context) ([]model.Product, error) { return nil, nil }
+	search := func(term string) {
+		t.Helper()
+		req := model.ListRequest{Search: term, SortField: "product_id", SortOrder: "ASC"}
+		if _, err := c.get(context.Background(), req, load); err != nil {
+			t.Fatalf("get: %v", err)
+		}
+	}
+
+	search("a")
+	search("b")
+	search("a")
+	search("c") // b を追い出す
+	search("a")
+	stats := c.stats()
+	if stats.Evictions != 1 || stats.Searches != 2 || stats.Hits != 2 {
+		t.Fatalf("stats = %+v, want 1 eviction, 2 searches, 2 hits", stats)
+	}
+	search("b")
+	if got := c.stats().Misses; got != 4 {
+		t.Errorf("misses = %d, want 4 after b was evicted", got)
+	}
+
+	// 絞り込みのない一覧は上限に数えない
+	for _, field := range []string{"product_id", "name", "value"} {
+		if _, err := c.get(context.Background(), model.ListRequest{SortField: field, SortOrder: "ASC"}, load); err != nil {
+			t.Fatalf("get: %v", err)
+		}
+	}
+	if stats := c.stats(); stats.Listings != 3 || stats.Searches != 2 {
+		t.Errorf("listings/searches = %d/%d, want 3/2", stats.Listings, stats.Searches)
+	}
+}
+
+func TestProductCatalogDropsLoadsRacingInvalidation(t *testing.T) {
+	c := newProductCatalog(time.Minute, 8)
+	req := model.ListRequest{SortField: "product_id", SortOrder: "ASC"}
+
+	// 読み込み中に書き込みがコミットされた結果はキャッシュしない
+	_, err := c.get(context.Background(), req, func(context.Context) ([]model.Product, error) {
+		c.invalidate()
+		return []model.Product{{ProductID: 1}}, nil
+	})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got := c.stats().Listings; got != 0 {
+		t.Fatalf("listings = %d, want the stale load to be dropped", got)
+	}
+
+	loaded := false
+	if _, err := c.get(context.Background(), req, func(context.Context) ([]model.Product, error) {
+		loaded = true
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !loaded {
+		t.Error("expected a fresh load after invalidation")
+	}
+}
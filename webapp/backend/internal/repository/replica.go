@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 読み取り用のレプリカ接続と、その死活監視
+// 監視で応答がなければプライマリに切り替え、回復したら戻す
+type Replica struct {
+	db      *sqlx.DB
+	healthy atomic.Bool
+	logger  *slog.Logger
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// interval ごとに timeout 付きで ping して状態を更新する
+// 最初の ping が終わるまではプライマリを使う
+func NewReplica(db *sqlx.DB, interval, timeout time.Duration, logger *slog.Logger) *Replica {
+	r := &Replica{db: db, logger: logger, stop: make(chan struct{})}
+	go r.monitor(interval, timeout)
+	return r
+}
+
+func (r *Replica) DB() *sqlx.DB {
+	return r.db
+}
+
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// 監視を止めて接続を閉じる
+func (r *Replica) Close() error {
+	r.once.Do(func() { close(r.stop) })
+	return r.db.Close()
+}
+
+func (r *Replica) monitor(interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.check(timeout)
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *Replica) check(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := r.db.PingContext(ctx)
+	healthy := err == nil
+	if r.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		r.logger.Info("read replica is healthy; routing reads to the replica")
+	} else {
+		r.logger.Warn("read replica is unhealthy; routing reads to the primary", "error", err)
+	}
+}
+
+type primaryKey struct{}
+
+// このコンテキストでの読み取りをプライマリに向ける
+// 書き込み直後に結果を読み返す処理で、レプリカの遅延を避けるために使う
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func usePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+// 読み取り専用のクエリをレプリカに振り分ける DBTX
+// レプリカがない、不調、または WithPrimary が指定されていればプライマリを使う
+// 書き込みは常にプライマリに流す
+type readRouter struct {
+	primary DBTX
+	replica *Replica
+}
+
+func newReadRouter(primary DBTX, replica *Replica) DBTX {
+	if replica == nil {
+		return primary
+	}
+	return &readRouter{primary: primary, replica: replica}
+}
+
+func (r *readRouter) pick(ctx context.Context) DBTX {
+	if usePrimary(ctx) || !r.replica.Healthy() {
+		return r.primary
+	}
+	return r.replica.db
+}
+
+func (r *readRouter) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return r.pick(ctx).GetContext(ctx, dest, query, args...)
+}
+
+func (r *readRouter) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return r.pick(ctx).SelectContext(ctx, dest, query, args...)
+}
+
+func (r *readRouter) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.primary.ExecContext(ctx, query, args...)
+}
+
+func (r *readRouter) Rebind(query string) string {
+	return r.primary.Rebind(query)
+}
@@ -2,16 +2,35 @@ package repository
 
 import (
 	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type SessionRepository struct {
-	db    DBTX
-	cache map[string]*sessionCacheEntry
-	mu    sync.RWMutex
+	db     DBTX
+	cache  *sessionCache
+	logger *slog.Logger
+}
+
+// トランザクション用のリポジトリとも共有するセッションキャッシュ
+type sessionCache struct {
+	mu      sync.RWMutex
+	entries map[string]*sessionCacheEntry
+	stop    chan struct{}
+	once    sync.Once
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+// セッションキャッシュの累計ヒット数とミス数
+type SessionCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
 }
 
 type sessionCacheEntry struct {
@@ -19,28 +38,63 @@ type sessionCacheEntry struct {
 	expiresAt time.Time
 }
 
-func NewSessionRepository(db DBTX) *SessionRepository {
+func NewSessionRepository(db DBTX, logger *slog.Logger) *SessionRepository {
 	repo := &SessionRepository{
-		db:    db,
-		cache: make(map[string]*sessionCacheEntry),
+		db:     db,
+		logger: logger,
+		cache: &sessionCache{
+			entries: make(map[string]*sessionCacheEntry),
+			stop:    make(chan struct{}),
+		},
 	}
 	go repo.cleanupCache()
 	return repo
 }
 
+// キャッシュを共有したまま接続先だけを差し替えたリポジトリを返す
+// クリーンアップ用のゴルーチンは起動しない
+func (r *SessionRepository) withDB(db DBTX) *SessionRepository {
+	return &SessionRepository{db: db, cache: r.cache, logger: r.logger}
+}
+
+func (r *SessionRepository) CacheStats() SessionCacheStats {
+	r.cache.mu.RLock()
+	entries := len(r.cache.entries)
+	r.cache.mu.RUnlock()
+	return SessionCacheStats{
+		Hits:    r.cache.hits.Load(),
+		Misses:  r.cache.misses.Load(),
+		Entries: entries,
+	}
+}
+
+// キャッシュのクリーンアップを停止する
+func (r *SessionRepository) Close() {
+	r.cache.once.Do(func() { close(r.cache.stop) })
+}
+
 func (r *SessionRepository) cleanupCache() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		r.mu.Lock()
+	for {
+		select {
+		case <-r.cache.stop:
+			return
+		case <-ticker.C:
+		}
+		r.cache.mu.Lock()
 		now := time.Now()
-		for sessionID, entry := range r.cache {
+		removed := 0
+		for sessionID, entry := range r.cache.entries {
 			if now.After(entry.expiresAt) {
-				delete(r.cache, sessionID)
+				delete(r.cache.entries, sessionID)
+				removed++
 			}
 		}
-		r.mu.Unlock()
+		remaining := len(r.cache.entries)
+		r.cache.mu.Unlock()
+		r.logger.Debug("session cache cleaned up", "removed", removed, "remaining", remaining)
 	}
 }
 
@@ -63,13 +117,15 @@ func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, dura
 
 // セッションIDからユーザーIDを取得
 func (r *SessionRepository) FindUserBySessionID(ctx context.Context, sessionID string) (int, error) {
-	r.mu.RLock()
-	entry, exists := r.cache[sessionID]
-	r.mu.RUnlock()
+	r.cache.mu.RLock()
+	entry, exists := r.cache.entries[sessionID]
+	r.cache.mu.RUnlock()
 
 	if exists && time.Now().Before(entry.expiresAt) {
+		r.cache.hits.Add(1)
 		return entry.userID, nil
 	}
+	r.cache.misses.Add(1)
 
 	type result struct {
 		UserID    int       `db:"user_id"`
@@ -87,12 +143,12 @@ func (r *SessionRepository) FindUserBySessionID(ctx context.Context, sessionID s
 		return 0, err
 	}
 
-	r.mu.Lock()
-	r.cache[sessionID] = &sessionCacheEntry{
+	r.cache.mu.Lock()
+	r.cache.entries[sessionID] = &sessionCacheEntry{
 		userID:    res.UserID,
 		expiresAt: res.ExpiresAt,
 	}
-	r.mu.Unlock()
+	r.cache.mu.Unlock()
 
 	return res.UserID, nil
 }
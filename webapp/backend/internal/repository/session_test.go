@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionRepositoryCreate(t *testing.T) {
+	store, db := newSQLiteStore(t, "users")
+	ctx := context.Background()
+
+	before := time.Now()
+	sessionID, expiresAt, err := store.SessionRepo.Create(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if len(sessionID) != 36 {
+		t.Errorf("session id = %q, want a UUID", sessionID)
+	}
+	if expiresAt.Before(before.Add(time.Hour)) || expiresAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expires at = %v, want about an hour from now", expiresAt)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM user_sessions WHERE session_uuid = ? AND user_id = 2", sessionID); n != 1 {
+		t.Errorf("stored sessions = %d, want 1", n)
+	}
+
+	userID, err := store.SessionRepo.FindUserBySessionID(ctx, sessionID)
+	if err != nil || userID != 2 {
+		t.Errorf("FindUserBySessionID = (%d, %v), want (2, nil)", userID, err)
+	}
+}
+
+func TestSessionRepositoryFindUserBySessionID(t *testing.T) {
+	store, _ := newSQLiteStore(t, "users", "user_sessions")
+
+	tests := []struct {
+		name      string
+		sessionID string
+		want      int
+		wantErr   error
+	}{
+		{"valid", "11111111-1111-1111-1111-111111111111", 1, nil},
+		{"expired", "22222222-2222-2222-2222-222222222222", 0, sql.ErrNoRows},
+		{"unknown", "33333333-3333-3333-3333-333333333333", 0, sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := store.SessionRepo.FindUserBySessionID(context.Background(), tt.sessionID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if userID != tt.want {
+				t.Errorf("user id = %d, want %d", userID, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionRepositoryCachesLookups(t *testing.T) {
+	store, db := newSQLiteStore(t, "users", "user_sessions")
+	ctx := context.Background()
+	const sessionID = "11111111-1111-1111-1111-111111111111"
+
+	if _, err := store.SessionRepo.FindUserBySessionID(ctx, sessionID); err != nil {
+		t.Fatalf("first lookup: %v", err)
+	}
+	// 2回目はキャッシュから返るので、DB から消しても見つかる
+	if _, err := db.Exec("DELETE FROM user_sessions WHERE session_uuid = ?", sessionID); err != nil {
+		t.Fatalf("delete session: %v", err)
+	}
+	userID, err := store.SessionRepo.FindUserBySessionID(ctx, sessionID)
+	if err != nil || userID != 1 {
+		t.Errorf("cached lookup = (%d, %v), want (1, nil)", userID, err)
+	}
+	if stats := store.SessionRepo.CacheStats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("cache stats = %+v, want 1 hit, 1 miss, 1 entry", stats)
+	}
+}
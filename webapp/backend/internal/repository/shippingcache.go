@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"backend/internal/telemetry"
+	"context"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// 修復時に 1 つの IN 句に並べる注文IDの上限
+const shippingCacheRepairChunk = 1000
+
+// shipping_order_cache を orders JOIN products WHERE shipped_status = 'shipping' と突き合わせる
+// レプリカの遅延をずれと誤認しないよう、常にプライマリで読む
+func (r *OrderRepository) CheckShippingCache(ctx context.Context) (_ model.ShippingCacheDrift, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "repository.order", "OrderRepository.CheckShippingCache")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	drift := model.ShippingCacheDrift{Missing: []int64{}, Stale: []int64{}, Orphaned: []int64{}}
+	missingQuery := `
+		SELECT o.order_id
+		FROM orders o
+		LEFT JOIN shipping_order_cache c ON c.order_id = o.order_id
+		WHERE o.shipped_status = 'shipping' AND c.order_id IS NULL
+		ORDER BY o.order_id
+	`
+	if err := r.db.SelectContext(ctx, &drift.Missing, missingQuery); err != nil {
+		return drift, err
+	}
+	staleQuery := `
+		SELECT c.order_id
+		FROM shipping_order_cache c
+		JOIN orders o ON o.order_id = c.order_id
+		JOIN products p ON p.product_id = o.product_id
+		WHERE o.shipped_status = 'shipping' AND (c.weight <> p.weight OR c.value <> p.value)
+		ORDER BY c.order_id
+	`
+	if err := r.db.SelectContext(ctx, &drift.Stale, staleQuery); err != nil {
+		return drift, err
+	}
+	orphanedQuery := `
+		SELECT c.order_id
+		FROM shipping_order_cache c
+		LEFT JOIN orders o ON o.order_id = c.order_id AND o.shipped_status = 'shipping'
+		WHERE o.order_id IS NULL
+		ORDER BY c.order_id
+	`
+	if err := r.db.SelectContext(ctx, &drift.Orphaned, orphanedQuery); err != nil {
+		return drift, err
+	}
+
+	span.SetAttributes(
+		attribute.Int("cache.missing", len(drift.Missing)),
+		attribute.Int("cache.stale", len(drift.Stale)),
+		attribute.Int("cache.orphaned", len(drift.Orphaned)),
+	)
+	return drift, nil
+}
+
+// CheckShippingCache で見つかったずれを直し、変更した行数を返す
+// 確認後に注文の状態が変わっていてもよいよう、各行の条件を確かめ直してから書き込む
+func (r *OrderRepository) RepairShippingCache(ctx context.Context, drift model.ShippingCacheDrift) (_ int64, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "repository.order", "OrderRepository.RepairShippingCache",
+		attribute.Int("cache.drift", drift.Total()),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	var repaired int64
+	steps := []struct {
+		query    string
+		orderIDs []int64
+	}{
+		{`
+			DELETE FROM shipping_order_cache
+			WHERE order_id IN (?)
+			  AND order_id NOT IN (SELECT order_id FROM orders WHERE shipped_status = 'shipping')
+		`, drift.Orphaned},
+		{`
+			INSERT INTO shipping_order_cache (order_id, weight, value)
+			SELECT o.order_id, p.weight, p.value
+			FROM orders o
+			JOIN products p ON p.product_id = o.product_id
+			WHERE o.order_id IN (?)
+			  AND o.shipped_status = 'shipping'
+			  AND NOT EXISTS (SELECT 1 FROM shipping_order_cache c WHERE c.order_id = o.order_id)
+		`, drift.Missing},
+		{`
+			UPDATE shipping_order_cache
+			SET
+				weight = (SELECT p.weight FROM orders o JOIN products p ON p.product_id = o.product_id WHERE o.order_id = shipping_order_cache.order_id),
+				value = (SELECT p.value FROM orders o JOIN products p ON p.product_id = o.product_id WHERE o.order_id = shipping_order_cache.order_id)
+			WHERE order_id IN (?)
+			  AND order_id IN (SELECT order_id FROM orders WHERE shipped_status = 'shipping')
+		`, drift.Stale},
+	}
+	for _, step := range steps {
+		for chunk := range slices.Chunk(step.orderIDs, shippingCacheRepairChunk) {
+			query, args, err := sqlx.In(step.query, chunk)
+			if err != nil {
+				return repaired, err
+			}
+			result, err := r.db.ExecContext(ctx, r.db.Rebind(query), args...)
+			if err != nil {
+				return repaired, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return repaired, err
+			}
+			repaired += n
+		}
+	}
+
+	span.SetAttributes(attribute.Int64("cache.repaired", repaired))
+	return repaired, nil
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestOrderRepositoryShippingCache(t *testing.T) {
+	tests := []struct {
+		name string
+		// フィクスチャを読み込んだ後に直接流す SQL
+		setup     []string
+		wantDrift model.ShippingCacheDrift
+	}{
+		{
+			name:      "in sync",
+			wantDrift: model.ShippingCacheDrift{Missing: []int64{}, Stale: []int64{}, Orphaned: []int64{}},
+		},
+		{
+			name: "status edited directly",
+			setup: []string{
+				"UPDATE orders SET shipped_status = 'shipping' WHERE order_id = 3",
+				"UPDATE orders SET shipped_status = 'completed' WHERE order_id = 1",
+			},
+			wantDrift: model.ShippingCacheDrift{Missing: []int64{3}, Stale: []int64{}, Orphaned: []int64{1}},
+		},
+		{
+			name:      "product changed without sync",
+			setup:     []string{"UPDATE products SET weight = 7 WHERE product_id = 4"},
+			wantDrift: model.ShippingCacheDrift{Missing: []int64{}, Stale: []int64{6}, Orphaned: []int64{}},
+		},
+		{
+			name: "cache rows lost or left behind",
+			setup: []string{
+				"DELETE FROM shipping_order_cache WHERE order_id IN (2, 5)",
+				"INSERT INTO shipping_order_cache (order_id, weight, value) VALUES (4, 3, 950)",
+				"UPDATE shipping_order_cache SET value = 1 WHERE order_id = 1",
+			},
+			wantDrift: model.ShippingCacheDrift{Missing: []int64{2, 5}, Stale: []int64{1}, Orphaned: []int64{4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, db := newSQLiteStore(t, allFixtures...)
+			ctx := context.Background()
+			for _, q := range tt.setup {
+				if _, err := db.Exec(q); err != nil {
+					t.Fatalf("setup %q: %v", q, err)
+				}
+			}
+
+			drift, err := store.OrderRepo.CheckShippingCache(ctx)
+			if err != nil {
+				t.Fatalf("CheckShippingCache: %v", err)
+			}
+			if !slices.Equal(drift.Missing, tt.wantDrift.Missing) ||
+				!slices.Equal(drift.Stale, tt.wantDrift.Stale) ||
+				!slices.Equal(drift.Orphaned, tt.wantDrift.Orphaned) {
+				t.Fatalf("drift = %+v, want %+v", drift, tt.wantDrift)
+			}
+
+			repaired, err := store.OrderRepo.RepairShippingCache(ctx, drift)
+			if err != nil {
+				t.Fatalf("RepairShippingCache: %v", err)
+			}
+			if repaired != int64(tt.wantDrift.Total()) {
+				t.Errorf("repaired = %d, want %d", repaired, tt.wantDrift.Total())
+			}
+			after, err := store.OrderRepo.CheckShippingCache(ctx)
+			if err != nil {
+				t.Fatalf("CheckShippingCache after repair: %v", err)
+			}
+			if after.Total() != 0 {
+				t.Errorf("drift after repair = %+v, want none", after)
+			}
+		})
+	}
+}
+
+func TestOrderRepositoryRepairShippingCacheRechecks(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+	ctx := context.Background()
+
+	if _, err := db.Exec("UPDATE orders SET shipped_status = 'shipping' WHERE order_id = 3"); err != nil {
+		t.Fatalf("update order: %v", err)
+	}
+	drift, err := store.OrderRepo.CheckShippingCache(ctx)
+	if err != nil {
+		t.Fatalf("CheckShippingCache: %v", err)
+	}
+	// 確認後にロボットが引き受けたので、もうキャッシュに入れてはいけない
+	if _, err := db.Exec("UPDATE orders SET shipped_status = 'delivering' WHERE order_id = 3"); err != nil {
+		t.Fatalf("update order: %v", err)
+	}
+	repaired, err := store.OrderRepo.RepairShippingCache(ctx, drift)
+	if err != nil {
+		t.Fatalf("RepairShippingCache: %v", err)
+	}
+	if repaired != 0 {
+		t.Errorf("repaired = %d, want 0", repaired)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM shipping_order_cache WHERE order_id = 3"); n != 0 {
+		t.Errorf("order 3 was added to shipping_order_cache")
+	}
+}
@@ -0,0 +1,322 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"cmp"
+	"container/heap"
+	"context"
+	"slices"
+	"sync"
+)
+
+// 配送待ちの注文をメモリに持つ索引
+// 重量ごとのバケットに分け、各バケットは価値の高い順に並べる
+// 注文の書き込み処理がコミット後に更新し、起動時と不整合に気付いたときに DB から読み直す
+type ShippingIndex struct {
+	// 読み直しと書き込みを直列にする
+	// 読み直しの間も Candidates は古い内容で答えられるよう、mu とは分けている
+	writeMu sync.Mutex
+	mu      sync.RWMutex
+	loaded  bool
+	orders  map[int64]model.Order
+	buckets map[int][]model.Order
+	// Take で取り出し、配送中にするコミットを待っている注文
+	// DB ではまだ配送待ちなので、読み直しても索引には戻さない
+	pending map[int64]model.Order
+}
+
+// 索引の中身を DB と突き合わせるための件数と合計
+type shippingFingerprint struct {
+	Orders    int64 `db:"orders"`
+	OrderIDs  int64 `db:"order_id_sum"`
+	WeightSum int64 `db:"weight_sum"`
+	ValueSum  int64 `db:"value_sum"`
+}
+
+func NewShippingIndex() *ShippingIndex {
+	return &ShippingIndex{
+		orders:  make(map[int64]model.Order),
+		buckets: make(map[int][]model.Order),
+		pending: make(map[int64]model.Order),
+	}
+}
+
+// 一度でも読み込んだか。読み込む前の変更は捨てる
+func (x *ShippingIndex) Loaded() bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.loaded
+}
+
+func (x *ShippingIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.orders)
+}
+
+// shipping_order_cache から作り直す
+// 読み込み中にコミットされた変更は、読み込み後に適用されるまで待たせる。変更はどれも同じ結果を上書きするだけなので、二重に適用してもよい
+// Take で取り出し中の注文は、配送中にするコミットが終わっていなくても入れない
+func (x *ShippingIndex) Load(ctx context.Context, db DBTX) error {
+	x.writeMu.Lock()
+	defer x.writeMu.Unlock()
+
+	var orders []model.Order
+	query := `
+		SELECT c.order_id, o.product_id, c.weight, c.value
+		FROM shipping_order_cache c
+		JOIN orders o ON o.order_id = c.order_id
+	`
+	if err := db.SelectContext(ctx, &orders, query); err != nil {
+		return err
+	}
+
+	byID := make(map[int64]model.Order, len(orders))
+	buckets := make(map[int][]model.Order)
+	for _, o := range orders {
+		// pending を変えるのはどれも writeMu を持ったときだけ
+		if _, ok := x.pending[o.OrderID]; ok {
+			continue
+		}
+		byID[o.OrderID] = o
+		buckets[o.Weight] = append(buckets[o.Weight], o)
+	}
+	for _, b := range buckets {
+		slices.SortFunc(b, compareShipping)
+	}
+
+	x.mu.Lock()
+	x.loaded = true
+	x.orders = byID
+	x.buckets = buckets
+	x.mu.Unlock()
+	return nil
+}
+
+// maxWeight 以下の注文を価値の高い順に返す
+// 返す注文には ID・重量・価値だけを入れる
+func (x *ShippingIndex) Candidates(maxWeight int) []model.Order {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	h := &bucketHeap{}
+	total := 0
+	for w, b := range x.buckets {
+		if w <= maxWeight && len(b) > 0 {
+			h.cursors = append(h.cursors, bucketCursor{orders: b})
+			total += len(b)
+		}
+	}
+	heap.Init(h)
+
+	candidates := make([]model.Order, 0, total)
+	for h.Len() > 0 {
+		c := &h.cursors[0]
+		o := c.orders[c.pos]
+		candidates = append(candidates, model.Order{OrderID: o.OrderID, Weight: o.Weight, Value: o.Value})
+		c.pos++
+		if c.pos == len(c.orders) {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return candidates
+}
+
+// orderIDs をすべて取り除き、取り除いた注文を返す。1 つでも無ければ何もせず false を返す
+// 配送計画で選んだ注文を、DB に書き込む前に他のロボットから隠すのに使う
+// コミットできれば Remove、書き込めなければ Restore で戻し、索引が古かった場合は Release してから読み直す
+func (x *ShippingIndex) Take(orderIDs []int64) ([]model.Order, bool) {
+	x.writeMu.Lock()
+	defer x.writeMu.Unlock()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	taken := make([]model.Order, 0, len(orderIDs))
+	for _, id := range orderIDs {
+		o, ok := x.orders[id]
+		if !ok {
+			return nil, false
+		}
+		taken = append(taken, o)
+	}
+	for _, o := range taken {
+		x.remove(o.OrderID)
+		x.pending[o.OrderID] = o
+	}
+	return taken, true
+}
+
+// Take で取り出した注文を、索引に戻さずに取り出し中でなくする
+func (x *ShippingIndex) Release(orderIDs ...int64) {
+	x.writeMu.Lock()
+	defer x.writeMu.Unlock()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, id := range orderIDs {
+		delete(x.pending, id)
+	}
+}
+
+// Take で取り出した注文のうち、まだ取り出し中のものを今の重量・価値で索引に戻す
+// 取り出し中に商品が削除された注文は戻さない
+func (x *ShippingIndex) Restore(orderIDs ...int64) {
+	x.update(func() {
+		for _, id := range orderIDs {
+			o, ok := x.pending[id]
+			if !ok {
+				continue
+			}
+			delete(x.pending, id)
+			x.remove(id)
+			x.insert(o)
+		}
+	})
+}
+
+// 注文を追加する。同じ ID があれば置き換える
+func (x *ShippingIndex) Put(orders ...model.Order) {
+	x.update(func() {
+		for _, o := range orders {
+			delete(x.pending, o.OrderID)
+			x.remove(o.OrderID)
+			x.insert(o)
+		}
+	})
+}
+
+func (x *ShippingIndex) Remove(orderIDs ...int64) {
+	x.update(func() {
+		for _, id := range orderIDs {
+			delete(x.pending, id)
+			x.remove(id)
+		}
+	})
+}
+
+// 商品の重量・価値の変更を反映する
+func (x *ShippingIndex) UpdateProduct(productID, weight, value int) {
+	x.update(func() {
+		var changed []model.Order
+		for _, o := range x.orders {
+			if o.ProductID == productID && (o.Weight != weight || o.Value != value) {
+				changed = append(changed, o)
+			}
+		}
+		for _, o := range changed {
+			x.remove(o.OrderID)
+			o.Weight, o.Value = weight, value
+			x.insert(o)
+		}
+		for id, o := range x.pending {
+			if o.ProductID == productID {
+				o.Weight, o.Value = weight, value
+				x.pending[id] = o
+			}
+		}
+	})
+}
+
+// 商品の削除で消えた注文を取り除く
+func (x *ShippingIndex) RemoveProduct(productID int) {
+	x.update(func() {
+		for id, o := range x.orders {
+			if o.ProductID == productID {
+				x.remove(id)
+			}
+		}
+		for id, o := range x.pending {
+			if o.ProductID == productID {
+				delete(x.pending, id)
+			}
+		}
+	})
+}
+
+// 読み込み済みの場合だけ fn を実行する。読み込む前の変更は Load が DB から拾う
+func (x *ShippingIndex) update(fn func()) {
+	x.writeMu.Lock()
+	defer x.writeMu.Unlock()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.loaded {
+		fn()
+	}
+}
+
+// 取り出し中の注文も含めた件数と合計。DB の shipping_order_cache と一致するはず
+func (x *ShippingIndex) fingerprint() shippingFingerprint {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var f shippingFingerprint
+	for _, m := range []map[int64]model.Order{x.orders, x.pending} {
+		for _, o := range m {
+			f.Orders++
+			f.OrderIDs += o.OrderID
+			f.WeightSum += int64(o.Weight)
+			f.ValueSum += int64(o.Value)
+		}
+	}
+	return f
+}
+
+// insert と remove は mu を持った状態で呼ぶ
+func (x *ShippingIndex) insert(o model.Order) {
+	x.orders[o.OrderID] = o
+	b := x.buckets[o.Weight]
+	i, _ := slices.BinarySearchFunc(b, o, compareShipping)
+	x.buckets[o.Weight] = slices.Insert(b, i, o)
+}
+
+func (x *ShippingIndex) remove(orderID int64) {
+	o, ok := x.orders[orderID]
+	if !ok {
+		return
+	}
+	delete(x.orders, orderID)
+	b := x.buckets[o.Weight]
+	if i, found := slices.BinarySearchFunc(b, o, compareShipping); found {
+		b = slices.Delete(b, i, i+1)
+	}
+	if len(b) == 0 {
+		delete(x.buckets, o.Weight)
+	} else {
+		x.buckets[o.Weight] = b
+	}
+}
+
+// 価値の高い順。同じ価値なら ID の小さい順
+func compareShipping(a, b model.Order) int {
+	if c := cmp.Compare(b.Value, a.Value); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.OrderID, b.OrderID)
+}
+
+// バケットを価値の高い順にマージするためのヒープ
+type bucketCursor struct {
+	orders []model.Order
+	pos    int
+}
+
+type bucketHeap struct {
+	cursors []bucketCursor
+}
+
+func (h *bucketHeap) Len() int { return len(h.cursors) }
+
+func (h *bucketHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	return compareShipping(a.orders[a.pos], b.orders[b.pos]) < 0
+}
+
+func (h *bucketHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *bucketHeap) Push(v any) { h.cursors = append(h.cursors, v.(bucketCursor)) }
+
+func (h *bucketHeap) Pop() any {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func newLoadedSQLiteStore(t *testing.T) (*Store, *sqlx.DB) {
+	t.Helper()
+	store, db := newSQLiteStore(t, allFixtures...)
+	if err := store.LoadShippingIndex(context.Background()); err != nil {
+		t.Fatalf("LoadShippingIndex: %v", err)
+	}
+	return store, db
+}
+
+// 索引が DB から作り直したものと同じ内容か確かめる
+func assertIndexInSync(t *testing.T, store *Store, db *sqlx.DB) {
+	t.Helper()
+	fresh := NewShippingIndex()
+	if err := fresh.Load(context.Background(), db); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !maps.Equal(store.ShippingIndex.orders, fresh.orders) {
+		t.Errorf("index = %v, want %v", store.ShippingIndex.orders, fresh.orders)
+	}
+	if got, want := store.ShippingIndex.Candidates(math.MaxInt), fresh.Candidates(math.MaxInt); !slices.Equal(got, want) {
+		t.Errorf("candidates = %v, want %v", got, want)
+	}
+}
+
+func TestShippingIndexCandidates(t *testing.T) {
+	store, _ := newLoadedSQLiteStore(t)
+
+	tests := []struct {
+		maxWeight int
+		want      []int64
+	}{
+		{0, nil},
+		{1, []int64{2, 5}},
+		{2, []int64{2, 1, 5}},
+		{5, []int64{6, 2, 1, 5}},
+	}
+	for _, tt := range tests {
+		got := orderIDs(store.ShippingIndex.Candidates(tt.maxWeight))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Candidates(%d) = %v, want %v", tt.maxWeight, got, tt.want)
+		}
+		// DB から直接読んだ場合と同じ並び
+		fromDB, err := store.OrderRepo.GetShippingOrdersOptimized(context.Background(), tt.maxWeight)
+		if err != nil {
+			t.Fatalf("GetShippingOrdersOptimized: %v", err)
+		}
+		if ids := orderIDs(fromDB); !slices.Equal(got, ids) {
+			t.Errorf("Candidates(%d) = %v, database returns %v", tt.maxWeight, got, ids)
+		}
+	}
+}
+
+func TestShippingIndexFollowsWrites(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		write func(*Store) error
+	}{
+		{"create", func(s *Store) error {
+			_, err := s.OrderRepo.Create(ctx, &model.Order{UserID: 1, ProductID: 3})
+			return err
+		}},
+		{"bulk create", func(s *Store) error {
+			_, err := s.OrderRepo.BulkCreate(ctx, []model.Order{{UserID: 2, ProductID: 1}, {UserID: 2, ProductID: 4}})
+			return err
+		}},
+		{"status to delivering", func(s *Store) error {
+			return s.OrderRepo.UpdateStatuses(ctx, []int64{1, 6}, "delivering")
+		}},
+		{"status back to shipping", func(s *Store) error {
+			return s.OrderRepo.UpdateStatuses(ctx, []int64{3}, "shipping")
+		}},
+		{"claim", func(s *Store) error {
+			_, err := s.OrderRepo.ClaimShippingOrders(ctx, []int64{2, 5})
+			return err
+		}},
+		{"product change", func(s *Store) error {
+			if _, err := s.db.ExecContext(ctx, "UPDATE products SET weight = 1, value = 2000 WHERE product_id = 4"); err != nil {
+				return err
+			}
+			return s.OrderRepo.SyncShippingCacheByProduct(ctx, 4)
+		}},
+		{"product delete", func(s *Store) error {
+			return s.ProductRepo.Delete(ctx, 1)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Run("without transaction", func(t *testing.T) {
+				store, db := newLoadedSQLiteStore(t)
+				if err := tt.write(store); err != nil {
+					t.Fatalf("write: %v", err)
+				}
+				assertIndexInSync(t, store, db)
+			})
+			t.Run("committed", func(t *testing.T) {
+				store, db := newLoadedSQLiteStore(t)
+				if err := store.ExecTx(ctx, tt.write); err != nil {
+					t.Fatalf("ExecTx: %v", err)
+				}
+				assertIndexInSync(t, store, db)
+			})
+			t.Run("rolled back", func(t *testing.T) {
+				store, db := newLoadedSQLiteStore(t)
+				before := maps.Clone(store.ShippingIndex.orders)
+				rollback := errors.New("rollback")
+				err := store.ExecTx(ctx, func(s *Store) error {
+					if err := tt.write(s); err != nil {
+						return err
+					}
+					return rollback
+				})
+				if !errors.Is(err, rollback) {
+					t.Fatalf("ExecTx error = %v, want %v", err, rollback)
+				}
+				if !maps.Equal(store.ShippingIndex.orders, before) {
+					t.Errorf("index changed by a rolled back transaction")
+				}
+				assertIndexInSync(t, store, db)
+			})
+		})
+	}
+}
+
+func TestShippingIndexIgnoresRolledBackSavepoints(t *testing.T) {
+	store, db := newLoadedSQLiteStore(t)
+	ctx := context.Background()
+	rollback := errors.New("rollback")
+
+	err := store.ExecTx(ctx, func(txStore *Store) error {
+		if _, err := txStore.OrderRepo.Create(ctx, &model.Order{UserID: 1, ProductID: 2}); err != nil {
+			return err
+		}
+		err := txStore.ExecTx(ctx, func(s *Store) error {
+			if err := s.OrderRepo.UpdateStatuses(ctx, []int64{1, 2}, "delivering"); err != nil {
+				return err
+			}
+			return rollback
+		})
+		if !errors.Is(err, rollback) {
+			t.Errorf("nested ExecTx error = %v, want %v", err, rollback)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExecTx: %v", err)
+	}
+	if got, want := orderIDs(store.ShippingIndex.Candidates(math.MaxInt)), []int64{6, 2, 1, 5, 7}; !slices.Equal(got, want) {
+		t.Errorf("candidates = %v, want %v", got, want)
+	}
+	assertIndexInSync(t, store, db)
+}
+
+func TestShippingIndexTake(t *testing.T) {
+	store, _ := newLoadedSQLiteStore(t)
+	x := store.ShippingIndex
+
+	if _, ok := x.Take([]int64{1, 3}); ok {
+		t.Fatal("Take succeeded with an order that is not shipping")
+	}
+	if x.Len() != 4 {
+		t.Errorf("Len = %d after a failed Take, want 4", x.Len())
+	}
+
+	taken, ok := x.Take([]int64{1, 6})
+	if !ok {
+		t.Fatal("Take failed")
+	}
+	if want := []model.Order{{OrderID: 1, ProductID: 1, Weight: 2, Value: 300}, {OrderID: 6, ProductID: 4, Weight: 5, Value: 1500}}; !slices.Equal(taken, want) {
+		t.Errorf("taken = %v, want %v", taken, want)
+	}
+	if _, ok := x.Take([]int64{6}); ok {
+		t.Error("the same order was taken twice")
+	}
+
+	x.Put(taken...)
+	if got, want := orderIDs(x.Candidates(math.MaxInt)), []int64{6, 2, 1, 5}; !slices.Equal(got, want) {
+		t.Errorf("candidates after Put = %v, want %v", got, want)
+	}
+}
+
+// 取り出している間の商品の変更・削除は、戻すときに反映する
+func TestShippingIndexRestore(t *testing.T) {
+	store, _ := newLoadedSQLiteStore(t)
+	x := store.ShippingIndex
+
+	if _, ok := x.Take([]int64{1, 6}); !ok {
+		t.Fatal("Take failed")
+	}
+	x.UpdateProduct(1, 3, 900)
+	x.RemoveProduct(4)
+	x.Restore(1, 6)
+
+	if got, want := x.Candidates(math.MaxInt), []model.Order{{OrderID: 1, Weight: 3, Value: 900}, {OrderID: 2, Weight: 1, Value: 800}, {OrderID: 5, Weight: 1, Value: 120}}; !slices.Equal(got, want) {
+		t.Errorf("candidates after Restore = %v, want %v", got, want)
+	}
+	if _, ok := x.Take([]int64{6}); ok {
+		t.Error("order of the deleted product was restored")
+	}
+	// 戻した注文はもう取り出し中ではない
+	x.Restore(1)
+	if x.Len() != 3 {
+		t.Errorf("Len = %d after a second Restore, want 3", x.Len())
+	}
+}
+
+// 別のロボットが取り出し中の注文は、読み直しても索引に戻さない
+func TestShippingIndexLoadSkipsTakenOrders(t *testing.T) {
+	store, db := newLoadedSQLiteStore(t)
+	ctx := context.Background()
+	x := store.ShippingIndex
+
+	if _, ok := x.Take([]int64{1, 6}); !ok {
+		t.Fatal("Take failed")
+	}
+	if err := store.LoadShippingIndex(ctx); err != nil {
+		t.Fatalf("LoadShippingIndex: %v", err)
+	}
+	if got, want := orderIDs(x.Candidates(math.MaxInt)), []int64{2, 5}; !slices.Equal(got, want) {
+		t.Errorf("candidates after Load = %v, want %v", got, want)
+	}
+	if fresh, err := store.ShippingIndexFresh(ctx); err != nil || !fresh {
+		t.Errorf("ShippingIndexFresh = %v, %v; want taken orders to count until they are claimed", fresh, err)
+	}
+
+	err := store.ExecTx(ctx, func(txStore *Store) error {
+		_, err := txStore.OrderRepo.ClaimShippingOrders(ctx, []int64{1})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ExecTx: %v", err)
+	}
+	x.Release(6)
+	if err := store.LoadShippingIndex(ctx); err != nil {
+		t.Fatalf("LoadShippingIndex: %v", err)
+	}
+	if got, want := orderIDs(x.Candidates(math.MaxInt)), []int64{6, 2, 5}; !slices.Equal(got, want) {
+		t.Errorf("candidates after Release = %v, want %v", got, want)
+	}
+	assertIndexInSync(t, store, db)
+}
+
+func TestShippingIndexFresh(t *testing.T) {
+	ctx := context.Background()
+	if fresh, err := NewStore(nil, nil, nil).ShippingIndexFresh(ctx); err != nil || fresh {
+		t.Errorf("ShippingIndexFresh before Load = %v, %v; want false", fresh, err)
+	}
+
+	tests := []struct {
+		name string
+		sql  string
+	}{
+		{"row removed", "DELETE FROM shipping_order_cache WHERE order_id = 2"},
+		{"row added", "INSERT INTO shipping_order_cache (order_id, weight, value) VALUES (3, 1, 100)"},
+		{"value changed", "UPDATE shipping_order_cache SET value = value + 1 WHERE order_id = 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, db := newLoadedSQLiteStore(t)
+			if fresh, err := store.ShippingIndexFresh(ctx); err != nil || !fresh {
+				t.Fatalf("ShippingIndexFresh after Load = %v, %v; want true", fresh, err)
+			}
+			// 索引を通さずに書き換える
+			if _, err := db.Exec(tt.sql); err != nil {
+				t.Fatalf("exec: %v", err)
+			}
+			if fresh, err := store.ShippingIndexFresh(ctx); err != nil || fresh {
+				t.Errorf("ShippingIndexFresh = %v, %v; want false", fresh, err)
+			}
+		})
+	}
+}
+
+func TestShippingIndexIgnoresWritesBeforeLoad(t *testing.T) {
+	x := NewShippingIndex()
+	x.Put(model.Order{OrderID: 1, Weight: 1, Value: 1})
+	if x.Loaded() || x.Len() != 0 {
+		t.Errorf("index before Load: loaded = %v, len = %d", x.Loaded(), x.Len())
+	}
+}
+
+func TestOrderRepositoryClaimShippingOrders(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+
+	claimed, err := store.OrderRepo.ClaimShippingOrders(context.Background(), []int64{1, 3, 5})
+	if err != nil {
+		t.Fatalf("ClaimShippingOrders: %v", err)
+	}
+	// 3 は既に配送中なので数えない
+	if claimed != 2 {
+		t.Errorf("claimed = %d, want 2", claimed)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM orders WHERE order_id IN (1, 3, 5) AND shipped_status = 'delivering'"); n != 3 {
+		t.Errorf("delivering orders = %d, want 3", n)
+	}
+	if rows := shippingCache(t, store); !slices.Equal(rows, []cacheRow{{2, 1, 800}, {6, 5, 1500}}) {
+		t.Errorf("shipping_order_cache = %+v", rows)
+	}
+}
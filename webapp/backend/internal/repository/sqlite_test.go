@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"backend/internal/repository/sqlitetest"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SQLite を使う Store を作り、指定したテーブルのフィクスチャを読み込む
+func newSQLiteStore(t *testing.T, tables ...string) (*Store, *sqlx.DB) {
+	t.Helper()
+	db := sqlitetest.OpenDB(t)
+	sqlitetest.LoadFixtures(t, db, tables...)
+	store := NewStore(db, nil, slog.New(slog.NewTextHandler(io.Discard, nil)), WithDialect(SQLite))
+	t.Cleanup(store.Close)
+	return store, db
+}
+
+// 件数を数えるテスト用のヘルパー
+func countRows(t *testing.T, db *sqlx.DB, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := db.GetContext(context.Background(), &n, query, args...); err != nil {
+		t.Fatalf("count rows: %v", err)
+	}
+	return n
+}
+
+// 全てのフィクスチャ。親テーブルから順に並べている
+var allFixtures = sqlitetest.AllFixtures
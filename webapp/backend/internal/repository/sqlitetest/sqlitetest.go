@@ -0,0 +1,101 @@
+// リポジトリとハンドラーのテストで共有する SQLite のデータベース
+// スキーマは testdata/schema.sql、初期データは testdata/fixtures/<table>.json に置く
+package sqlitetest
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//go:embed testdata/schema.sql testdata/fixtures/*.json
+var testdata embed.FS
+
+// 全てのフィクスチャ。親テーブルから順に並べている
+var AllFixtures = []string{"users", "products", "orders", "shipping_order_cache", "user_order_counts", "user_sessions"}
+
+// フィクスチャのユーザー 1 (alice) の有効なセッション
+const SessionID = "11111111-1111-1111-1111-111111111111"
+
+// テストごとにスキーマを適用した SQLite データベースを作る
+// 外部キー制約を有効にし、ファイルはテスト終了時に消える
+// 並行するトランザクションが書き込みロックの昇格で失敗しないよう、開始時にロックを取る
+func OpenDB(t testing.TB) *sqlx.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sqlx.Open("sqlite3", "file:"+path+"?_fk=1&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := testdata.ReadFile("testdata/schema.sql")
+	if err != nil {
+		t.Fatalf("read schema: %v", err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("apply schema: %v", err)
+	}
+	return db
+}
+
+// スキーマを適用し、全てのフィクスチャを読み込んだデータベースを作る
+func Open(t testing.TB) *sqlx.DB {
+	t.Helper()
+	db := OpenDB(t)
+	LoadFixtures(t, db, AllFixtures...)
+	return db
+}
+
+// フィクスチャの行を挿入する
+// ファイルはオブジェクトの配列で、キーを列名として扱う。外部キーの都合で親テーブルから順に指定する
+func LoadFixtures(t testing.TB, db *sqlx.DB, tables ...string) {
+	t.Helper()
+	for _, table := range tables {
+		data, err := testdata.ReadFile("testdata/fixtures/" + table + ".json")
+		if err != nil {
+			t.Fatalf("read fixture %s: %v", table, err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var rows []map[string]any
+		if err := dec.Decode(&rows); err != nil {
+			t.Fatalf("decode fixture %s: %v", table, err)
+		}
+		for i, row := range rows {
+			columns := make([]string, 0, len(row))
+			for c := range row {
+				columns = append(columns, c)
+			}
+			slices.Sort(columns)
+			args := make([]any, len(columns))
+			for j, c := range columns {
+				args[j] = fixtureValue(row[c])
+			}
+			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+				table, strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", "))
+			if _, err := db.Exec(query, args...); err != nil {
+				t.Fatalf("insert fixture %s[%d]: %v", table, i, err)
+			}
+		}
+	}
+}
+
+func fixtureValue(v any) any {
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	}
+	return v
+}
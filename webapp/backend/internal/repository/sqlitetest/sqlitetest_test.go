@@ -0,0 +1,56 @@
+package sqlitetest
+
+import (
+	"os"
+	"regexp"
+	"testing"
+
+	"backend/internal/migrate"
+)
+
+var (
+	createTablePattern = regexp.MustCompile("(?i)CREATE TABLE (?:IF NOT EXISTS )?`?(\\w+)`?")
+	addColumnPattern   = regexp.MustCompile("(?i)ALTER TABLE `?(\\w+)`? ADD COLUMN `?(\\w+)`?")
+)
+
+// マイグレーションで追加したテーブルと列が SQLite のスキーマにもあるか確かめる
+// マイグレーションは MySQL 向けで SQLite では実行できないため、testdata/schema.sql を手で合わせている
+func TestSchemaFollowsMigrations(t *testing.T) {
+	migrations, err := migrate.Load(os.DirFS("../../migrate/migrations"))
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	db := OpenDB(t)
+	hasColumn := func(table, column string) bool {
+		t.Helper()
+		var n int
+		if err := db.Get(&n, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE ? = '' OR name = ?", table, column, column); err != nil {
+			t.Fatalf("table info %s: %v", table, err)
+		}
+		return n > 0
+	}
+
+	for _, m := range migrations {
+		for _, match := range createTablePattern.FindAllStringSubmatch(m.Up, -1) {
+			if !hasColumn(match[1], "") {
+				t.Errorf("%s creates table %s, missing from testdata/schema.sql", m, match[1])
+			}
+		}
+		for _, match := range addColumnPattern.FindAllStringSubmatch(m.Up, -1) {
+			if !hasColumn(match[1], match[2]) {
+				t.Errorf("%s adds %s.%s, missing from testdata/schema.sql", m, match[1], match[2])
+			}
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	db := Open(t)
+	var userID int
+	if err := db.Get(&userID, "SELECT user_id FROM user_sessions WHERE session_uuid = ?", SessionID); err != nil {
+		t.Fatalf("session %s: %v", SessionID, err)
+	}
+	if userID != 1 {
+		t.Errorf("session user = %d, want 1", userID)
+	}
+}
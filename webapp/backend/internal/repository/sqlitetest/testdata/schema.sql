@@ -0,0 +1,55 @@
+-- mysql/init/init.sql とマイグレーションを適用した後のスキーマを SQLite で表したもの
+-- マイグレーションは MySQL 向けで SQLite では実行できないため、テーブルや列を追加したらこちらにも反映する (TestSchemaFollowsMigrations で確かめる)
+CREATE TABLE users (
+    user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    password_hash VARCHAR(255) NOT NULL,
+    user_name VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL DEFAULT 'user'
+);
+
+CREATE TABLE products (
+    product_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    value INTEGER NOT NULL,
+    weight INTEGER NOT NULL,
+    image VARCHAR(500),
+    description TEXT
+);
+
+CREATE TABLE orders (
+    order_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    shipped_status VARCHAR(50) NOT NULL,
+    created_at DATETIME NOT NULL,
+    arrived_at DATETIME,
+    batch_token CHAR(36),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_batch_token ON orders (batch_token);
+
+CREATE TABLE user_sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_uuid VARCHAR(36) NOT NULL UNIQUE,
+    user_id INTEGER NOT NULL,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE shipping_order_cache (
+    order_id INTEGER PRIMARY KEY,
+    weight INTEGER NOT NULL,
+    value INTEGER NOT NULL,
+    FOREIGN KEY (order_id) REFERENCES orders(order_id) ON DELETE CASCADE
+);
+CREATE INDEX idx_shipping_lookup ON shipping_order_cache (weight, value DESC);
+
+CREATE TABLE user_order_counts (
+    user_id INTEGER NOT NULL,
+    shipped_status VARCHAR(50) NOT NULL,
+    order_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, shipped_status),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
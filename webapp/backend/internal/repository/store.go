@@ -2,44 +2,213 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type Store struct {
-	db          DBTX
+	db      DBTX
+	dialect Dialect
+	logger  *slog.Logger
+	// トランザクションの入れ子の深さ。セーブポイントの名前に使う
+	depth int
+	// コミット後に実行する処理。トランザクションの外では nil
+	hooks *commitHooks
+	// 配送待ちの注文の索引。トランザクション用の Store とも共有する
+	ShippingIndex *ShippingIndex
+	// 商品一覧のキャッシュ。設定されていなければ nil
+	catalog     *productCatalog
 	UserRepo    *UserRepository
 	SessionRepo *SessionRepository
 	ProductRepo *ProductRepository
 	OrderRepo   *OrderRepository
 }
 
-func NewStore(db DBTX) *Store {
-	return &Store{
-		db:          db,
-		UserRepo:    NewUserRepository(db),
-		SessionRepo: NewSessionRepository(db),
-		ProductRepo: NewProductRepository(db),
-		OrderRepo:   NewOrderRepository(db),
+// トランザクションのコミット後に実行する処理
+// メモリ上の状態は、ロールバックされうる変更を反映しないようコミットを待って更新する
+type commitHooks struct {
+	fns []func()
+}
+
+// トランザクションの外(h が nil)ではすぐに実行する
+func (h *commitHooks) add(fn func()) {
+	if h == nil {
+		fn()
+		return
+	}
+	h.fns = append(h.fns, fn)
+}
+
+func (h *commitHooks) run() {
+	for _, fn := range h.fns {
+		fn()
+	}
+}
+
+type StoreOption func(*Store)
+
+// SQL の方言を指定する。既定は MySQL
+func WithDialect(d Dialect) StoreOption {
+	return func(s *Store) { s.dialect = d }
+}
+
+// 商品一覧をキャッシュする。ttl が 0 ならキャッシュしない
+func WithProductCatalog(ttl time.Duration, maxSearches int) StoreOption {
+	return func(s *Store) {
+		if ttl > 0 {
+			s.catalog = newProductCatalog(ttl, maxSearches)
+		}
+	}
+}
+
+// replica を渡すと、商品・注文の一覧などの読み取りをレプリカに振り分ける
+// 認証に使うユーザー・セッションの読み取りは常にプライマリを使う
+func NewStore(db DBTX, replica *Replica, logger *slog.Logger, opts ...StoreOption) *Store {
+	s := &Store{db: db, dialect: MySQL, logger: logger, ShippingIndex: NewShippingIndex()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	read := newReadRouter(db, replica)
+	s.UserRepo = NewUserRepository(db)
+	s.SessionRepo = NewSessionRepository(db, logger)
+	s.ProductRepo = NewProductRepository(db, read, s.dialect, s.ShippingIndex)
+	s.ProductRepo.catalog = s.catalog
+	s.OrderRepo = NewOrderRepository(db, read, s.dialect, s.ShippingIndex)
+	return s
+}
+
+// 配送待ちの注文の索引を DB から作り直す
+func (s *Store) LoadShippingIndex(ctx context.Context) error {
+	return s.ShippingIndex.Load(ctx, s.db)
+}
+
+// 索引が shipping_order_cache と一致するかを、件数と ID・重量・価値の合計で確かめる
+// 起動後のリストアや SQL での直接の書き換えで、索引だけが古くなっていないかを見るのに使う
+func (s *Store) ShippingIndexFresh(ctx context.Context) (bool, error) {
+	if !s.ShippingIndex.Loaded() {
+		return false, nil
 	}
+	var db shippingFingerprint
+	query := `
+		SELECT COUNT(*) AS orders, COALESCE(SUM(order_id), 0) AS order_id_sum,
+			COALESCE(SUM(weight), 0) AS weight_sum, COALESCE(SUM(value), 0) AS value_sum
+		FROM shipping_order_cache
+	`
+	if err := s.db.GetContext(ctx, &db, query); err != nil {
+		return false, err
+	}
+	return s.ShippingIndex.fingerprint() == db, nil
 }
 
-func (s *Store) ExecTx(ctx context.Context, fn func(txStore *Store) error) error {
-	db, ok := s.db.(*sqlx.DB)
-	if !ok {
-		return fn(s)
+// fn をトランザクション内で実行し、エラーがなければコミットする
+// デッドロックやロック待ちのタイムアウトで失敗した場合は、少し待ってから fn ごとやり直す
+// トランザクション内の Store から呼ぶとセーブポイントを使い、失敗時はそこまでだけ戻す
+func (s *Store) ExecTx(ctx context.Context, fn func(txStore *Store) error, opts ...TxOption) error {
+	if tx, ok := s.db.(Tx); ok {
+		return s.execSavepoint(ctx, tx, fn)
+	}
+
+	var db TxBeginner
+	switch v := s.db.(type) {
+	case *sqlx.DB:
+		db = sqlxBeginner{v}
+	case TxBeginner:
+		db = v
+	default:
+		return fmt.Errorf("%w: %T", ErrTxUnsupported, s.db)
+	}
+
+	o := newTxOptions(opts)
+	for attempt := 1; ; attempt++ {
+		err := s.execTx(ctx, db, &o.sql, fn)
+		if err == nil || !isRetryableTxError(err) || attempt >= o.maxAttempts {
+			return err
+		}
+		delay := txRetryDelay(attempt)
+		s.logger.WarnContext(ctx, "retrying transaction", "attempt", attempt, "delay", delay.String(), "error", err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
 	}
+}
 
-	tx, err := db.BeginTxx(ctx, nil)
+func (s *Store) execTx(ctx context.Context, db TxBeginner, opts *sql.TxOptions, fn func(txStore *Store) error) error {
+	tx, err := db.BeginTx(ctx, opts)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.logger.WarnContext(ctx, "transaction rollback failed", "error", err)
+		}
+	}()
+
+	hooks := &commitHooks{}
+	if err := fn(s.withDB(tx, hooks)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	hooks.run()
+	return nil
+}
 
-	txStore := NewStore(tx)
-	if err := fn(txStore); err != nil {
+// 入れ子の ExecTx。外側のトランザクションの中でセーブポイントを切る
+// 再試行は外側のトランザクションに任せる
+func (s *Store) execSavepoint(ctx context.Context, tx Tx, fn func(txStore *Store) error) error {
+	inner := s.withDB(tx, s.hooks)
+	name := fmt.Sprintf("sp_%d", s.depth)
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	var pending int
+	if s.hooks != nil {
+		pending = len(s.hooks.fns)
+	}
+	if err := fn(inner); err != nil {
+		// 戻した変更のための処理は実行しない
+		if s.hooks != nil {
+			s.hooks.fns = s.hooks.fns[:pending]
+		}
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
 		return err
 	}
+	_, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// トランザクション内で使う Store を作る
+// 読み取りも同じトランザクションで行う。セッションキャッシュと配送待ちの索引は元の Store と共有する
+func (s *Store) withDB(db DBTX, hooks *commitHooks) *Store {
+	productRepo := NewProductRepository(db, db, s.dialect, s.ShippingIndex)
+	productRepo.catalog = s.catalog
+	productRepo.hooks = hooks
+	orderRepo := NewOrderRepository(db, db, s.dialect, s.ShippingIndex)
+	orderRepo.hooks = hooks
+	return &Store{
+		db:            db,
+		dialect:       s.dialect,
+		logger:        s.logger,
+		depth:         s.depth + 1,
+		hooks:         hooks,
+		ShippingIndex: s.ShippingIndex,
+		catalog:       s.catalog,
+		UserRepo:      NewUserRepository(db),
+		SessionRepo:   s.SessionRepo.withDB(db),
+		ProductRepo:   productRepo,
+		OrderRepo:     orderRepo,
+	}
+}
 
-	return tx.Commit()
+// バックグラウンドで動いている処理を停止する
+func (s *Store) Close() {
+	s.SessionRepo.Close()
 }
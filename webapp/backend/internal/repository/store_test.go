@@ -0,0 +1,344 @@
+package repository
+
+import (
+	"backend/internal/model"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DBTX のテストダブル
+// 実行された SQL とトランザクション操作を記録する。結果を返すクエリには対応せず、呼ばれたらテストを失敗させる
+type fakeDB struct {
+	t   *testing.T
+	log []string
+	// BeginTx に渡されたオプション
+	txOpts []*sql.TxOptions
+}
+
+func newFakeDB(t *testing.T) *fakeDB {
+	return &fakeDB{t: t}
+}
+
+func (db *fakeDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db.t.Fatalf("unexpected GetContext: %s", query)
+	return nil
+}
+
+func (db *fakeDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	db.t.Fatalf("unexpected SelectContext: %s", query)
+	return nil
+}
+
+func (db *fakeDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	db.log = append(db.log, query)
+	return driverResult{}, nil
+}
+
+func (db *fakeDB) Rebind(query string) string {
+	return query
+}
+
+func (db *fakeDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	db.log = append(db.log, "BEGIN")
+	db.txOpts = append(db.txOpts, opts)
+	return &fakeTx{fakeDB: db}, nil
+}
+
+type fakeTx struct {
+	*fakeDB
+	done bool
+}
+
+func (tx *fakeTx) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	tx.log = append(tx.log, "COMMIT")
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	tx.log = append(tx.log, "ROLLBACK")
+	return nil
+}
+
+type driverResult struct{}
+
+func (driverResult) LastInsertId() (int64, error) { return 0, nil }
+func (driverResult) RowsAffected() (int64, error) { return 0, nil }
+
+// トランザクションを扱えない DBTX
+type plainDB struct {
+	db *fakeDB
+}
+
+func (p plainDB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return p.db.GetContext(ctx, dest, query, args...)
+}
+
+func (p plainDB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return p.db.SelectContext(ctx, dest, query, args...)
+}
+
+func (p plainDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.db.ExecContext(ctx, query, args...)
+}
+
+func (p plainDB) Rebind(query string) string {
+	return p.db.Rebind(query)
+}
+
+func newTestStore(t *testing.T, db DBTX) *Store {
+	t.Helper()
+	store := NewStore(db, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(store.Close)
+	return store
+}
+
+var (
+	deadlock     = &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	duplicateKey = &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+)
+
+func TestExecTxCommits(t *testing.T) {
+	db := newFakeDB(t)
+	store := newTestStore(t, db)
+
+	err := store.ExecTx(context.Background(), func(txStore *Store) error {
+		_, err := txStore.db.ExecContext(context.Background(), "UPDATE orders")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ExecTx: %v", err)
+	}
+	if want := []string{"BEGIN", "UPDATE orders", "COMMIT"}; !slices.Equal(db.log, want) {
+		t.Errorf("log = %q, want %q", db.log, want)
+	}
+}
+
+func TestExecTxRollsBackOnError(t *testing.T) {
+	db := newFakeDB(t)
+	store := newTestStore(t, db)
+	boom := errors.New("boom")
+
+	err := store.ExecTx(context.Background(), func(*Store) error { return boom })
+	if !errors.Is(err, boom) {
+		t.Fatalf("ExecTx error = %v, want %v", err, boom)
+	}
+	if want := []string{"BEGIN", "ROLLBACK"}; !slices.Equal(db.log, want) {
+		t.Errorf("log = %q, want %q", db.log, want)
+	}
+}
+
+func TestExecTxOptions(t *testing.T) {
+	db := newFakeDB(t)
+	store := newTestStore(t, db)
+
+	err := store.ExecTx(context.Background(), func(*Store) error { return nil },
+		WithIsolation(sql.LevelReadCommitted), ReadOnly())
+	if err != nil {
+		t.Fatalf("ExecTx: %v", err)
+	}
+	want := sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: true}
+	if len(db.txOpts) != 1 || *db.txOpts[0] != want {
+		t.Errorf("tx options = %+v, want %+v", db.txOpts, want)
+	}
+}
+
+func TestExecTxRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		errs         []error
+		opts         []TxOption
+		wantErr      error
+		wantAttempts int
+	}{
+		{"deadlock then success", []error{deadlock, nil}, nil, nil, 2},
+		{"lock wait timeout then success", []error{&mysql.MySQLError{Number: 1205}, nil}, nil, nil, 2},
+		{"gives up after max attempts", []error{deadlock, deadlock, deadlock, nil}, nil, deadlock, defaultTxAttempts},
+		{"custom max attempts", []error{deadlock, deadlock, deadlock, nil}, []TxOption{WithMaxAttempts(4)}, nil, 4},
+		{"no retry when disabled", []error{deadlock, nil}, []TxOption{WithMaxAttempts(1)}, deadlock, 1},
+		{"other mysql errors are not retried", []error{duplicateKey, nil}, nil, duplicateKey, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeDB(t)
+			store := newTestStore(t, db)
+
+			attempts := 0
+			err := store.ExecTx(context.Background(), func(*Store) error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			}, tt.opts...)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ExecTx error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if begins := countOf(db.log, "BEGIN"); begins != attempts {
+				t.Errorf("transactions begun = %d, want %d", begins, attempts)
+			}
+		})
+	}
+}
+
+func TestExecTxStopsRetryingWhenContextIsDone(t *testing.T) {
+	db := newFakeDB(t)
+	store := newTestStore(t, db)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	err := store.ExecTx(ctx, func(*Store) error {
+		attempts++
+		cancel()
+		return deadlock
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecTx error = %v, want %v", err, context.Canceled)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestExecTxNestedUsesSavepoints(t *testing.T) {
+	db := newFakeDB(t)
+	store := newTestStore(t, db)
+	inner := errors.New("inner failed")
+
+	err := store.ExecTx(context.Background(), func(txStore *Store) error {
+		if err := txStore.ExecTx(context.Background(), func(s *Store) error {
+			return s.ExecTx(context.Background(), func(*Store) error { return nil })
+		}); err != nil {
+			return err
+		}
+		// 内側の失敗は外側で握りつぶせる
+		if err := txStore.ExecTx(context.Background(), func(*Store) error { return inner }); !errors.Is(err, inner) {
+			t.Errorf("nested ExecTx error = %v, want %v", err, inner)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExecTx: %v", err)
+	}
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT sp_1",
+		"SAVEPOINT sp_2",
+		"RELEASE SAVEPOINT sp_2",
+		"RELEASE SAVEPOINT sp_1",
+		"SAVEPOINT sp_1",
+		"ROLLBACK TO SAVEPOINT sp_1",
+		"COMMIT",
+	}
+	if !slices.Equal(db.log, want) {
+		t.Errorf("log = %q, want %q", db.log, want)
+	}
+}
+
+func TestExecTxNestedDoesNotRetry(t *testing.T) {
+	db := newFakeDB(t)
+	store := newTestStore(t, db)
+
+	inner := 0
+	err := store.ExecTx(context.Background(), func(txStore *Store) error {
+		return txStore.ExecTx(context.Background(), func(*Store) error {
+			inner++
+			if inner == 1 {
+				return deadlock
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("ExecTx: %v", err)
+	}
+	// 外側のトランザクションごとやり直す
+	if inner != 2 || countOf(db.log, "BEGIN") != 2 {
+		t.Errorf("inner calls = %d, transactions = %d, want 2 and 2", inner, countOf(db.log, "BEGIN"))
+	}
+}
+
+func TestExecTxWithoutTransactionSupport(t *testing.T) {
+	db := plainDB{newFakeDB(t)}
+	store := newTestStore(t, db)
+
+	called := false
+	err := store.ExecTx(context.Background(), func(*Store) error {
+		called = true
+		return nil
+	})
+	if !errors.Is(err, ErrTxUnsupported) {
+		t.Errorf("ExecTx error = %v, want %v", err, ErrTxUnsupported)
+	}
+	if called {
+		t.Error("fn was called without a transaction")
+	}
+}
+
+func countOf(log []string, entry string) int {
+	n := 0
+	for _, l := range log {
+		if l == entry {
+			n++
+		}
+	}
+	return n
+}
+
+func TestExecTxWithSQLite(t *testing.T) {
+	store, db := newSQLiteStore(t, allFixtures...)
+	ctx := context.Background()
+	inner := errors.New("inner failed")
+
+	err := store.ExecTx(ctx, func(txStore *Store) error {
+		if _, err := txStore.OrderRepo.Create(ctx, &model.Order{UserID: 1, ProductID: 2}); err != nil {
+			return err
+		}
+		// セーブポイントまで戻るので、この注文だけが消える
+		err := txStore.ExecTx(ctx, func(s *Store) error {
+			if _, err := s.OrderRepo.Create(ctx, &model.Order{UserID: 1, ProductID: 3}); err != nil {
+				return err
+			}
+			return inner
+		})
+		if !errors.Is(err, inner) {
+			t.Errorf("nested ExecTx error = %v, want %v", err, inner)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExecTx: %v", err)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM orders WHERE order_id > 6"); n != 1 {
+		t.Errorf("new orders = %d, want 1", n)
+	}
+
+	err = store.ExecTx(ctx, func(txStore *Store) error {
+		if err := txStore.OrderRepo.UpdateStatuses(ctx, []int64{1, 2, 5, 6}, "delivering"); err != nil {
+			return err
+		}
+		return inner
+	})
+	if !errors.Is(err, inner) {
+		t.Fatalf("ExecTx error = %v, want %v", err, inner)
+	}
+	if n := countRows(t, db, "SELECT COUNT(*) FROM orders WHERE shipped_status = 'shipping'"); n != 5 {
+		t.Errorf("shipping orders after rollback = %d, want 5", n)
+	}
+}
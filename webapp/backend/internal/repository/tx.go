@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// ExecTx に渡された DBTX がトランザクションを扱えない
+var ErrTxUnsupported = errors.New("DBTX does not support transactions")
+
+// トランザクション中の DBTX
+type Tx interface {
+	DBTX
+	Commit() error
+	Rollback() error
+}
+
+// トランザクションを開始できる DBTX
+// *sqlx.DB はそのまま扱えるので実装しなくてよい
+type TxBeginner interface {
+	DBTX
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
+}
+
+type sqlxBeginner struct {
+	*sqlx.DB
+}
+
+func (db sqlxBeginner) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	return db.BeginTxx(ctx, opts)
+}
+
+const (
+	// 再試行を含めた既定の試行回数
+	defaultTxAttempts = 3
+	txRetryBaseDelay  = 20 * time.Millisecond
+	txRetryMaxDelay   = 500 * time.Millisecond
+)
+
+// 再試行すればやり直せる MySQL のエラー
+var retryableTxErrors = map[uint16]bool{
+	1205: true, // ロック待ちのタイムアウト
+	1213: true, // デッドロック
+}
+
+type txOptions struct {
+	sql         sql.TxOptions
+	maxAttempts int
+}
+
+type TxOption func(*txOptions)
+
+// 分離レベルを指定する。入れ子のトランザクションでは無視する
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) { o.sql.Isolation = level }
+}
+
+// 読み取り専用のトランザクションにする。入れ子のトランザクションでは無視する
+func ReadOnly() TxOption {
+	return func(o *txOptions) { o.sql.ReadOnly = true }
+}
+
+// デッドロックなどで失敗したときの最大試行回数。1 なら再試行しない
+func WithMaxAttempts(n int) TxOption {
+	return func(o *txOptions) { o.maxAttempts = max(n, 1) }
+}
+
+func newTxOptions(opts []TxOption) txOptions {
+	o := txOptions{maxAttempts: defaultTxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func isRetryableTxError(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && retryableTxErrors[myErr.Number]
+}
+
+// attempt 回目の失敗後に待つ時間
+// 指数的に伸ばした上限までの範囲でランダムに選び、同時に失敗した処理がぶつからないようにする
+func txRetryDelay(attempt int) time.Duration {
+	limit := min(txRetryBaseDelay<<attempt, txRetryMaxDelay)
+	return rand.N(limit) + 1
+}
+
+// d だけ待つ。その間に ctx が終われば ctx のエラーを返す
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -20,7 +20,7 @@ func NewUserRepository(db DBTX) *UserRepository {
 // ログイン時に使用
 func (r *UserRepository) FindByUserName(ctx context.Context, userName string) (*model.User, error) {
 	var user model.User
-	query := "SELECT user_id, password_hash, user_name FROM users WHERE user_name = ?"
+	query := "SELECT user_id, password_hash, user_name, role FROM users WHERE user_name = ?"
 
 	err := r.db.GetContext(ctx, &user, query, userName)
 	if err != nil {
@@ -31,3 +31,14 @@ func (r *UserRepository) FindByUserName(ctx context.Context, userName string) (*
 	}
 	return &user, nil
 }
+
+// ユーザーIDからロールを取得
+// 管理者APIの認可に使用
+func (r *UserRepository) FindRoleByID(ctx context.Context, userID int) (string, error) {
+	var role string
+	query := "SELECT role FROM users WHERE user_id = ?"
+	if err := r.db.GetContext(ctx, &role, query, userID); err != nil {
+		return "", err
+	}
+	return role, nil
+}
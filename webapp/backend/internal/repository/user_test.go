@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestUserRepositoryFindByUserName(t *testing.T) {
+	store, _ := newSQLiteStore(t, "users")
+
+	tests := []struct {
+		userName string
+		wantID   int
+		wantRole string
+		wantErr  error
+	}{
+		{"alice", 1, "user", nil},
+		{"admin", 3, "admin", nil},
+		{"nobody", 0, "", sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userName, func(t *testing.T) {
+			user, err := store.UserRepo.FindByUserName(context.Background(), tt.userName)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if user.UserID != tt.wantID || user.UserName != tt.userName || user.Role != tt.wantRole {
+				t.Errorf("user = %+v, want id %d, role %q", user, tt.wantID, tt.wantRole)
+			}
+			if user.PasswordHash == "" {
+				t.Error("password hash is empty")
+			}
+		})
+	}
+}
+
+func TestUserRepositoryFindRoleByID(t *testing.T) {
+	store, _ := newSQLiteStore(t, "users")
+
+	tests := []struct {
+		name    string
+		userID  int
+		want    string
+		wantErr error
+	}{
+		{"user", 1, "user", nil},
+		{"admin", 3, "admin", nil},
+		{"unknown", 99, "", sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := store.UserRepo.FindRoleByID(context.Background(), tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if role != tt.want {
+				t.Errorf("role = %q, want %q", role, tt.want)
+			}
+		})
+	}
+}
@@ -1,76 +1,186 @@
 package server
 
 import (
+	"backend/internal/apperror"
+	"backend/internal/config"
 	"backend/internal/db"
 	"backend/internal/handler"
+	"backend/internal/health"
+	"backend/internal/imagestore"
+	"backend/internal/metrics"
 	"backend/internal/middleware"
+	"backend/internal/migrate"
 	"backend/internal/repository"
 	"backend/internal/service"
-	"log"
+	"backend/internal/telemetry"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	_ "net/http/pprof"
-	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/riandyrn/otelchi"
 )
 
 type Server struct {
 	Router *chi.Mux
+	cfg    *config.Config
+	db     *sqlx.DB
+	// レプリカを設定していなければ nil
+	replica *repository.Replica
+	store   *repository.Store
+	// shipping_order_cache の定期チェック
+	shippingCache *service.ShippingCacheService
+	health        *health.Checker
+	logger        *slog.Logger
+	// nil の場合は停止処理を行わない
+	telemetry *telemetry.Telemetry
+	// シャットダウン開始後は false になり、レディネスチェックが失敗する
+	ready atomic.Bool
 }
 
-func NewServer() (*Server, *sqlx.DB, error) {
-	dbConn, err := db.InitDBConnection()
+func NewServer(cfg *config.Config, logger *slog.Logger, tel *telemetry.Telemetry) (*Server, error) {
+	dbConn, err := db.InitDBConnection(cfg.Database, cfg.Telemetry, logger)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	store := repository.NewStore(dbConn)
+	migrator, err := migrate.New(dbConn, logger)
+	if err != nil {
+		dbConn.Close()
+		return nil, err
+	}
 
-	authService := service.NewAuthService(store)
-	orderService := service.NewOrderService(store)
-	productService := service.NewProductService(store)
-	robotService := service.NewRobotService(store)
+	replicaConn, err := db.InitReplicaConnection(cfg.Database, cfg.Telemetry, logger)
+	if err != nil {
+		dbConn.Close()
+		return nil, err
+	}
+	var replica *repository.Replica
+	if replicaConn != nil {
+		replica = repository.NewReplica(replicaConn, cfg.Database.ReplicaCheckInterval.Std(), cfg.Database.PingTimeout.Std(), logger)
+	}
 
-	authHandler := handler.NewAuthHandler(authService)
-	productHandler := handler.NewProductHandler(productService)
-	orderHandler := handler.NewOrderHandler(orderService)
-	robotHandler := handler.NewRobotHandler(robotService)
+	store := repository.NewStore(dbConn, replica, logger,
+		repository.WithProductCatalog(cfg.ProductCache.TTL.Std(), cfg.ProductCache.MaxSearches),
+	)
+	// マイグレーション前でテーブルがなくても起動できるよう、失敗しても最初の配送計画で読み直す
+	loadStart := time.Now()
+	if err := store.LoadShippingIndex(context.Background()); err != nil {
+		logger.Warn("failed to load shipping index; it will be loaded on the first delivery plan", "error", err)
+	} else {
+		logger.Info("shipping index loaded", "orders", store.ShippingIndex.Len(), "duration", time.Since(loadStart).String())
+	}
 
-	userAuthMW := middleware.UserAuthMiddleware(store.SessionRepo)
+	m := metrics.New()
+	m.MustRegister(
+		collectors.NewDBStatsCollector(dbConn.DB, "mysql"),
+		metrics.NewSessionCacheCollector(store.SessionRepo),
+		metrics.NewProductCatalogCollector(store.ProductRepo),
+	)
+	if replica != nil {
+		m.MustRegister(collectors.NewDBStatsCollector(replica.DB().DB, "mysql_replica"))
+	}
+
+	authService := service.NewAuthService(store, cfg.Timeouts, logger)
+	orderService := service.NewOrderService(store, cfg.Timeouts)
+	productService := service.NewProductService(store, cfg.Timeouts, logger, m)
+	robotService := service.NewRobotService(store, cfg.Timeouts, logger, m)
+	shippingCacheService := service.NewShippingCacheService(store, logger, m)
+
+	images := imagestore.New(cfg.Images.Dir, logger)
+	imageCache := imagestore.NewCache(cfg.Images.CacheBytes, cfg.Images.CacheItemBytes)
+
+	authHandler := handler.NewAuthHandler(authService, cookieConfig(cfg.Auth), logger)
+	productHandler := handler.NewProductHandler(productService, images, imageCache, logger)
+	orderHandler := handler.NewOrderHandler(orderService, logger)
+	robotHandler := handler.NewRobotHandler(robotService, logger)
+	adminProductHandler := handler.NewAdminProductHandler(productService, images, logger)
+
+	userAuthMW := middleware.UserAuthMiddleware(store.SessionRepo, logger)
+	adminMW := middleware.AdminOnlyMiddleware(store.UserRepo, logger)
+
+	if cfg.Auth.RobotAPIKey == config.Default().Auth.RobotAPIKey {
+		// キーの値そのものはログに出さない
+		logger.Warn("ROBOT_API_KEY is not set; using the default key")
+	}
+	robotAuthMW := middleware.RobotAuthMiddleware(cfg.Auth.RobotAPIKey)
 
-	robotAPIKey := os.Getenv("ROBOT_API_KEY")
-	if robotAPIKey == "" {
-		log.Println("Warning: ROBOT_API_KEY is not set. Using default key 'test-robot-key'")
-		robotAPIKey = "test-robot-key"
+	csrfMW := middleware.CSRFMiddleware()
+	if !cfg.Auth.CSRFEnabled {
+		logger.Warn("CSRF_ENABLED is false; CSRF protection is disabled")
+		csrfMW = func(next http.Handler) http.Handler { return next }
 	}
-	robotAuthMW := middleware.RobotAuthMiddleware(robotAPIKey)
 
-	r := chi.NewRouter()
+	s := &Server{
+		Router:        chi.NewRouter(),
+		cfg:           cfg,
+		db:            dbConn,
+		replica:       replica,
+		store:         store,
+		shippingCache: shippingCacheService,
+		logger:        logger,
+		telemetry:     tel,
+	}
+	s.ready.Store(true)
+
+	s.health = health.NewChecker(cfg.Server.HealthCheckTimeout.Std())
+	s.health.Add("shutdown", func(context.Context) (any, error) {
+		if !s.ready.Load() {
+			return nil, errors.New("server is shutting down")
+		}
+		return nil, nil
+	})
+	s.health.Add("database", health.DBPing(dbConn))
+	s.health.Add("connection_pool", health.DBPool(dbConn))
+	s.health.Add("migration", health.Migration(migrator))
+	if replica != nil {
+		s.health.Add("replica", health.Replica(replica))
+	}
+
+	r := s.Router
+	// トレースを取らず、成功したときはアクセスログも出さないパス
+	probePaths := []string{"/api/health", "/livez", "/readyz", "/metrics"}
+	r.Use(middleware.RequestID)
 	r.Use(otelchi.Middleware(
 		"backend-api",
 		otelchi.WithChiRoutes(r),
 		otelchi.WithFilter(func(req *http.Request) bool {
-			return req.URL.Path != "/api/health"
+			return !slices.Contains(probePaths, req.URL.Path)
 		}),
 	))
+	r.Use(middleware.AccessLog(logger, s.cfg.Log.SlowRequestThreshold.Std(), probePaths...))
+	r.Use(middleware.HTTPMetrics(m))
+	r.Use(middleware.ReadConsistency)
 
-	r.Get("/api/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("ok"))
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		apperror.Write(w, r, apperror.New(apperror.CodeNotFound, "Resource not found"))
 	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		apperror.Write(w, r, apperror.New(apperror.CodeMethodNotAllowed, "Method not allowed"))
+	})
+
+	r.Get("/livez", s.livez)
+	r.Get("/readyz", s.readyz)
+	// 既存の docker compose のヘルスチェック向けに残している
+	r.Get("/api/health", s.readyz)
+
+	r.Handle("/metrics", m.Handler())
 
 	// Add pprof endpoints for profiling
 	r.Mount("/debug/pprof", http.DefaultServeMux)
 
-	s := &Server{
-		Router: r,
-	}
-
-	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, userAuthMW, robotAuthMW)
+	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, adminProductHandler, userAuthMW, adminMW, robotAuthMW, csrfMW)
 
-	return s, dbConn, nil
+	return s, nil
 }
 
 func (s *Server) setupRoutes(
@@ -78,34 +188,161 @@ func (s *Server) setupRoutes(
 	productHandler *handler.ProductHandler,
 	orderHandler *handler.OrderHandler,
 	robotHandler *handler.RobotHandler,
+	adminProductHandler *handler.AdminProductHandler,
 	userAuthMW func(http.Handler) http.Handler,
+	adminMW func(http.Handler) http.Handler,
 	robotAuthMW func(http.Handler) http.Handler,
+	csrfMW func(http.Handler) http.Handler,
 ) {
-	s.Router.Post("/api/login", authHandler.Login)
+	timeoutMW := middleware.HandlerTimeout(s.cfg.Server.HandlerTimeout.Std())
+
+	s.Router.With(timeoutMW).Post("/api/login", authHandler.Login)
+	s.Router.With(timeoutMW).Post("/api/login/token", authHandler.LoginToken)
 
 	s.Router.Route("/api/v1", func(r chi.Router) {
 		r.Use(userAuthMW)
-		r.Post("/product", productHandler.List)
-		r.Post("/product/post", productHandler.CreateOrders)
-		r.Post("/orders", orderHandler.List)
+		r.Group(func(r chi.Router) {
+			r.Use(timeoutMW)
+			r.Post("/product", productHandler.List)
+			r.With(csrfMW).Post("/product/post", productHandler.CreateOrders)
+			r.Post("/orders", orderHandler.List)
+		})
 		r.Get("/image", productHandler.GetImage)
 	})
 
+	s.Router.Route("/api/admin", func(r chi.Router) {
+		r.Use(userAuthMW, adminMW, csrfMW, timeoutMW, middleware.PrimaryReads)
+		r.Post("/products", adminProductHandler.Create)
+		r.Get("/products/{productID}", adminProductHandler.Get)
+		r.Put("/products/{productID}", adminProductHandler.Update)
+		r.Delete("/products/{productID}", adminProductHandler.Delete)
+		r.Post("/products/{productID}/image", adminProductHandler.UploadImage)
+		r.Get("/cache/stats", adminProductHandler.CacheStats)
+	})
+
 	s.Router.Route("/api/robot", func(r chi.Router) {
-		r.Use(robotAuthMW)
+		r.Use(robotAuthMW, timeoutMW)
 		r.Get("/delivery-plan", robotHandler.GetDeliveryPlan)
 		r.Patch("/orders/status", robotHandler.UpdateOrderStatus)
 	})
 }
 
-func (s *Server) Run() {
-	appPort := os.Getenv("PORT")
-	if appPort == "" {
-		appPort = "8080"
+// プロセスが応答できるかだけを返す。依存先の状態は見ない
+func (s *Server) livez(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// 依存先ごとのチェック結果を返す。1つでも失敗していれば 503
+// マイグレーション待ちや負荷でも失敗するので、人やロードバランサー向け。コンテナのヘルスチェックは /livez を使う
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	report := s.health.Run(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != health.StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// セッションCookieの属性を設定から決める
+func cookieConfig(cfg config.AuthConfig) handler.CookieConfig {
+	c := handler.CookieConfig{
+		Secure:   cfg.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	switch strings.ToLower(cfg.CookieSameSite) {
+	case "strict":
+		c.SameSite = http.SameSiteStrictMode
+	case "none":
+		// SameSite=None は Secure 属性がないとブラウザに拒否される
+		c.SameSite = http.SameSiteNoneMode
+		c.Secure = true
+	}
+	return c
+}
+
+// ctx がキャンセルされるまでリクエストを受け付け、その後グレースフルにシャットダウンする
+// 停止順序: ヘルスチェックを失敗させる → HTTP のドレイン → バックグラウンド処理 → トレースの送信 → DB
+func (s *Server) Run(ctx context.Context) error {
+	appPort := s.cfg.Server.Port
+
+	httpServer := &http.Server{
+		Addr:              ":" + appPort,
+		Handler:           s.Router,
+		ReadTimeout:       s.cfg.Server.ReadTimeout.Std(),
+		ReadHeaderTimeout: s.cfg.Server.ReadHeaderTimeout.Std(),
+		WriteTimeout:      s.cfg.Server.WriteTimeout.Std(),
+		IdleTimeout:       s.cfg.Server.IdleTimeout.Std(),
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		s.logger.Info("starting server", "addr", httpServer.Addr)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		if interval := s.cfg.ShippingCache.CheckInterval.Std(); interval > 0 {
+			s.logger.Info("starting shipping_order_cache checks", "interval", interval.String(), "auto_repair", s.cfg.ShippingCache.AutoRepair)
+			s.shippingCache.RunPeriodically(jobsCtx, interval, s.cfg.ShippingCache.AutoRepair)
+		}
+	}()
+
+	var runErr error
+	select {
+	case err := <-errCh:
+		runErr = err
+		s.logger.Error("server stopped unexpectedly", "error", err)
+	case <-ctx.Done():
+		s.logger.Info("shutdown signal received")
 	}
 
-	log.Printf("Starting server on :%s", appPort)
-	if err := http.ListenAndServe(":"+appPort, s.Router); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	s.ready.Store(false)
+	if runErr == nil {
+		// ロードバランサーがヘルスチェックの失敗に気付くまで新規リクエストを受け付け続ける
+		if delay := s.cfg.Server.ShutdownDrainDelay.Std(); delay > 0 {
+			s.logger.Info("waiting before draining connections", "delay", delay.String())
+			time.Sleep(delay)
+		}
+	}
+
+	// テレメトリの送信分を残して、処理中のリクエストを待つ
+	telemetryTimeout := s.cfg.Server.TelemetryShutdownTimeout.Std()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.Server.ShutdownTimeout.Std()-telemetryTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		s.logger.Error("HTTP server shutdown failed", "error", err)
+		runErr = errors.Join(runErr, err)
+	}
+	stopJobs()
+	<-jobsDone
+	s.store.Close()
+	if s.telemetry != nil {
+		telemetryCtx, cancelTelemetry := context.WithTimeout(context.Background(), telemetryTimeout)
+		defer cancelTelemetry()
+		if err := s.telemetry.Shutdown(telemetryCtx); err != nil {
+			s.logger.Warn("telemetry shutdown failed", "error", err)
+		}
+	}
+	if s.replica != nil {
+		if err := s.replica.Close(); err != nil {
+			s.logger.Warn("replica database close failed", "error", err)
+		}
+	}
+	if err := s.db.Close(); err != nil {
+		s.logger.Error("database close failed", "error", err)
+		runErr = errors.Join(runErr, err)
 	}
+	s.logger.Info("server stopped")
+	return runErr
 }
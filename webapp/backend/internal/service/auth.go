@@ -4,13 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"log/slog"
 	"time"
 
+	"backend/internal/config"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
+	"backend/internal/telemetry"
 
-	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -21,40 +23,46 @@ var (
 )
 
 type AuthService struct {
-	store *repository.Store
+	store    *repository.Store
+	timeouts config.TimeoutsConfig
+	logger   *slog.Logger
 }
 
-func NewAuthService(store *repository.Store) *AuthService {
-	return &AuthService{store: store}
+func NewAuthService(store *repository.Store, timeouts config.TimeoutsConfig, logger *slog.Logger) *AuthService {
+	return &AuthService{store: store, timeouts: timeouts, logger: logger}
 }
 
-func (s *AuthService) Login(ctx context.Context, userName, password string) (string, time.Time, error) {
-	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.Login")
-	defer span.End()
+func (s *AuthService) Login(ctx context.Context, userName, password string) (_ string, _ time.Time, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "service.auth", "AuthService.Login")
+	defer func() {
+		outcome, spanErr := loginOutcome(err)
+		span.SetAttributes(attribute.String("auth.outcome", outcome))
+		telemetry.EndSpan(span, spanErr)
+	}()
 
 	var sessionID string
 	var expiresAt time.Time
-	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+	err = utils.WithTimeout(ctx, s.timeouts.Login.Std(), func(ctx context.Context) error {
 		user, err := s.store.UserRepo.FindByUserName(ctx, userName)
 		if err != nil {
-			log.Printf("[Login] ユーザー検索失敗(userName: %s): %v", userName, err)
 			if errors.Is(err, sql.ErrNoRows) {
+				s.logger.InfoContext(ctx, "login failed: unknown user", "user_name", userName)
 				return ErrUserNotFound
 			}
+			s.logger.ErrorContext(ctx, "login failed: user lookup", "user_name", userName, "error", err)
 			return ErrInternalServer
 		}
 
 		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 		if err != nil {
-			log.Printf("[Login] パスワード検証失敗: %v", err)
-			span.RecordError(err)
+			s.logger.InfoContext(ctx, "login failed: password mismatch", "user_name", userName)
 			return ErrInvalidPassword
 		}
 
 		sessionDuration := 24 * time.Hour
 		sessionID, expiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, sessionDuration)
 		if err != nil {
-			log.Printf("[Login] セッション生成失敗: %v", err)
+			s.logger.ErrorContext(ctx, "login failed: session creation", "user_id", user.UserID, "error", err)
 			return ErrInternalServer
 		}
 		return nil
@@ -62,6 +70,21 @@ func (s *AuthService) Login(ctx context.Context, userName, password string) (str
 	if err != nil {
 		return "", time.Time{}, err
 	}
-	log.Printf("Login successful for UserName '%s', session created.", userName)
+	s.logger.InfoContext(ctx, "login succeeded", "user_name", userName)
 	return sessionID, expiresAt, nil
 }
+
+// ログインの結果と、スパンにエラーとして記録するエラーを返す
+// ユーザー名やパスワードの誤りは通常の結果なので、本当の障害と区別できるようエラーにしない
+func loginOutcome(err error) (string, error) {
+	switch {
+	case err == nil:
+		return "success", nil
+	case errors.Is(err, ErrUserNotFound):
+		return "unknown_user", nil
+	case errors.Is(err, ErrInvalidPassword):
+		return "invalid_password", nil
+	default:
+		return "error", err
+	}
+}
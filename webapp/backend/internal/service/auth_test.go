@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"backend/internal/config"
+	"backend/internal/repository"
+	"backend/internal/repository/sqlitetest"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ユーザー名やパスワードの誤りはスパンのエラーにせず、結果の属性で区別する
+func TestAuthServiceLoginSpanOutcome(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		_ = tp.Shutdown(context.Background())
+	})
+
+	db := sqlitetest.Open(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	if _, err := db.Exec("UPDATE users SET password_hash = ? WHERE user_name = 'alice'", string(hash)); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := repository.NewStore(db, nil, logger, repository.WithDialect(repository.SQLite))
+	t.Cleanup(store.Close)
+	svc := NewAuthService(store, config.Default().Timeouts, logger)
+
+	tests := []struct {
+		name        string
+		userName    string
+		password    string
+		closeDB     bool
+		wantErr     error
+		wantOutcome string
+		wantStatus  codes.Code
+	}{
+		{"success", "alice", "secret", false, nil, "success", codes.Unset},
+		{"unknown user", "nobody", "secret", false, ErrUserNotFound, "unknown_user", codes.Unset},
+		{"wrong password", "alice", "wrong", false, ErrInvalidPassword, "invalid_password", codes.Unset},
+		{"database error", "alice", "secret", true, ErrInternalServer, "error", codes.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.closeDB {
+				db.Close()
+			}
+			rec.Reset()
+			_, _, err := svc.Login(context.Background(), tt.userName, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Login error = %v, want %v", err, tt.wantErr)
+			}
+
+			var span sdktrace.ReadOnlySpan
+			for _, s := range rec.Ended() {
+				if s.Name() == "AuthService.Login" {
+					span = s
+				}
+			}
+			if span == nil {
+				t.Fatal("no AuthService.Login span")
+			}
+			if got := span.Status().Code; got != tt.wantStatus {
+				t.Errorf("span status = %v, want %v", got, tt.wantStatus)
+			}
+			want := attribute.String("auth.outcome", tt.wantOutcome)
+			found := false
+			for _, a := range span.Attributes() {
+				found = found || a == want
+			}
+			if !found {
+				t.Errorf("span attributes = %v, want %v", span.Attributes(), want)
+			}
+		})
+	}
+}
@@ -1,34 +1,60 @@
 package service
 
 import (
+	"backend/internal/config"
 	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
+	"backend/internal/telemetry"
 	"context"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type OrderService struct {
-	store *repository.Store
+	store    *repository.Store
+	timeouts config.TimeoutsConfig
 }
 
-func NewOrderService(store *repository.Store) *OrderService {
-	return &OrderService{store: store}
+func NewOrderService(store *repository.Store, timeouts config.TimeoutsConfig) *OrderService {
+	return &OrderService{store: store, timeouts: timeouts}
 }
 
 // ユーザーの注文履歴を取得
-func (s *OrderService) FetchOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
+func (s *OrderService) FetchOrders(ctx context.Context, userID int, req model.ListRequest) (_ []model.Order, _ model.ListPage, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "service.order", "OrderService.FetchOrders",
+		append(listAttributes(req), attribute.Int("user.id", userID))...)
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	var orders []model.Order
-	var total int
-	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+	var page model.ListPage
+	err = utils.WithTimeout(ctx, s.timeouts.FetchOrders.Std(), func(ctx context.Context) error {
 		var fetchErr error
-		orders, total, fetchErr = s.store.OrderRepo.ListOrders(ctx, userID, req)
+		orders, page, fetchErr = s.store.OrderRepo.ListOrders(ctx, userID, req)
 		if fetchErr != nil {
 			return fetchErr
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, 0, err
+		return nil, model.ListPage{}, err
+	}
+	span.SetAttributes(attribute.Int("list.returned", len(orders)), attribute.Bool("list.has_more", page.HasMore))
+	if page.Total != nil {
+		span.SetAttributes(attribute.Int("list.total", *page.Total))
+	}
+	return orders, page, nil
+}
+
+// 一覧取得の検索条件をスパンの属性にする
+// 検索語そのものは個人情報を含みうるので長さだけ残す
+func listAttributes(req model.ListRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("search.type", req.Type),
+		attribute.Int("search.length", len(req.Search)),
+		attribute.String("list.sort_field", req.SortField),
+		attribute.String("list.sort_order", req.SortOrder),
+		attribute.Int("list.page", req.Page),
+		attribute.Int("list.page_size", req.PageSize),
 	}
-	return orders, total, nil
 }
@@ -2,55 +2,196 @@ package service
 
 import (
 	"context"
-	"log"
+	"database/sql"
+	"errors"
+	"log/slog"
 
+	"backend/internal/config"
+	"backend/internal/metrics"
 	"backend/internal/model"
 	"backend/internal/repository"
+	"backend/internal/service/utils"
+	"backend/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrInvalidProduct  = errors.New("invalid product")
 )
 
 type ProductService struct {
-	store *repository.Store
+	store    *repository.Store
+	timeouts config.TimeoutsConfig
+	logger   *slog.Logger
+	metrics  *metrics.Metrics
 }
 
-func NewProductService(store *repository.Store) *ProductService {
-	return &ProductService{store: store}
+func NewProductService(store *repository.Store, timeouts config.TimeoutsConfig, logger *slog.Logger, m *metrics.Metrics) *ProductService {
+	return &ProductService{store: store, timeouts: timeouts, logger: logger, metrics: m}
 }
 
-func (s *ProductService) CreateOrders(ctx context.Context, userID int, items []model.RequestItem) ([]string, error) {
+func (s *ProductService) CreateOrders(ctx context.Context, userID int, items []model.RequestItem) (_ []string, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "service.product", "ProductService.CreateOrders",
+		attribute.Int("user.id", userID),
+		attribute.Int("order.items", len(items)),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	var insertedOrderIDs []string
 
-	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-		var orders []model.Order
-		for _, item := range items {
-			if item.Quantity > 0 {
-				for i := 0; i < item.Quantity; i++ {
-					orders = append(orders, model.Order{
-						UserID:    userID,
-						ProductID: item.ProductID,
-					})
+	err = utils.WithTimeout(ctx, s.timeouts.CreateOrders.Std(), func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var orders []model.Order
+			for _, item := range items {
+				if item.Quantity > 0 {
+					for i := 0; i < item.Quantity; i++ {
+						orders = append(orders, model.Order{
+							UserID:    userID,
+							ProductID: item.ProductID,
+						})
+					}
 				}
 			}
-		}
-		if len(orders) == 0 {
+			span.SetAttributes(attribute.Int("order.requested", len(orders)))
+			if len(orders) == 0 {
+				return nil
+			}
+
+			orderIDs, err := txStore.OrderRepo.BulkCreate(ctx, orders)
+			if err != nil {
+				return err
+			}
+			insertedOrderIDs = orderIDs
 			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("order.created", len(insertedOrderIDs)))
+	s.metrics.AddOrdersCreated(len(insertedOrderIDs))
+	s.logger.InfoContext(ctx, "orders created", "user_id", userID, "count", len(insertedOrderIDs))
+	return insertedOrderIDs, nil
+}
+
+func (s *ProductService) FetchProducts(ctx context.Context, userID int, req model.ListRequest) (_ []model.Product, _ int, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "service.product", "ProductService.FetchProducts", listAttributes(req)...)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	var products []model.Product
+	var total int
+	err = utils.WithTimeout(ctx, s.timeouts.FetchProducts.Std(), func(ctx context.Context) error {
+		var fetchErr error
+		products, total, fetchErr = s.store.ProductRepo.ListProducts(ctx, userID, req)
+		return fetchErr
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	span.SetAttributes(attribute.Int("list.total", total), attribute.Int("list.returned", len(products)))
+	return products, total, nil
+}
+
+func (s *ProductService) GetProduct(ctx context.Context, productID int) (*model.Product, error) {
+	product, err := s.store.ProductRepo.FindByID(ctx, productID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProductNotFound
 		}
+		return nil, err
+	}
+	return product, nil
+}
+
+func (s *ProductService) CreateProduct(ctx context.Context, req model.ProductRequest) (*model.Product, error) {
+	if err := validateProductRequest(req); err != nil {
+		return nil, err
+	}
+	product := productFromRequest(req)
+	id, err := s.store.ProductRepo.Create(ctx, &product)
+	if err != nil {
+		return nil, err
+	}
+	product.ProductID = id
+	s.logger.InfoContext(ctx, "product created", "product_id", id)
+	return &product, nil
+}
 
-		orderIDs, err := txStore.OrderRepo.BulkCreate(ctx, orders)
+// 商品を更新する
+// 重量・価値が変わった場合は配送待ちキャッシュも同じトランザクションで更新する
+func (s *ProductService) UpdateProduct(ctx context.Context, productID int, req model.ProductRequest) (*model.Product, error) {
+	if err := validateProductRequest(req); err != nil {
+		return nil, err
+	}
+	product := productFromRequest(req)
+	product.ProductID = productID
+
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		current, err := txStore.ProductRepo.FindByID(ctx, productID)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrProductNotFound
+			}
+			return err
+		}
+		if err := txStore.ProductRepo.Update(ctx, &product); err != nil {
 			return err
 		}
-		insertedOrderIDs = orderIDs
+		if current.Weight != product.Weight || current.Value != product.Value {
+			if err := txStore.OrderRepo.SyncShippingCacheByProduct(ctx, productID); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
-
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("Created %d orders for user %d", len(insertedOrderIDs), userID)
-	return insertedOrderIDs, nil
+	s.logger.InfoContext(ctx, "product updated", "product_id", productID)
+	return &product, nil
 }
 
-func (s *ProductService) FetchProducts(ctx context.Context, userID int, req model.ListRequest) ([]model.Product, int, error) {
-	products, total, err := s.store.ProductRepo.ListProducts(ctx, userID, req)
-	return products, total, err
+// 消える注文の数を user_order_counts から差し引くのと同じトランザクションで削除する
+func (s *ProductService) DeleteProduct(ctx context.Context, productID int) error {
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		return txStore.ProductRepo.Delete(ctx, productID)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrProductNotFound
+		}
+		return err
+	}
+	s.logger.InfoContext(ctx, "product deleted", "product_id", productID)
+	return nil
+}
+
+// 保存済みの画像ファイルを商品に紐づける
+func (s *ProductService) SetProductImage(ctx context.Context, productID int, image string) error {
+	return s.store.ProductRepo.UpdateImage(ctx, productID, image)
+}
+
+// 商品一覧キャッシュの統計
+func (s *ProductService) CatalogStats() repository.CatalogStats {
+	return s.store.ProductRepo.CatalogStats()
+}
+
+func validateProductRequest(req model.ProductRequest) error {
+	if req.Name == "" || req.Value < 0 || req.Weight <= 0 {
+		return ErrInvalidProduct
+	}
+	return nil
+}
+
+func productFromRequest(req model.ProductRequest) model.Product {
+	return model.Product{
+		Name:        req.Name,
+		Value:       req.Value,
+		Weight:      req.Weight,
+		Image:       req.Image,
+		Description: req.Description,
+	}
 }
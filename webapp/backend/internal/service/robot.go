@@ -1,63 +1,207 @@
 package service
 
 import (
+	"backend/internal/config"
+	"backend/internal/metrics"
 	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
+	"backend/internal/telemetry"
 	"context"
-	"log"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 配送計画で他のロボットと注文を取り合ったときに計画をやり直す回数
+const maxPlanAttempts = 5
+
+const (
+	// 配送待ちの索引を DB と突き合わせる間隔
+	shippingIndexVerifyInterval = 5 * time.Second
+	// 索引に候補が無いときに DB と突き合わせる間隔。リストア直後の索引もすぐに読み直せるよう短くする
+	emptyPlanVerifyInterval = time.Second
+)
+
+var (
+	// 何度やり直しても他のロボットと注文を取り合った
+	ErrPlanConflict = errors.New("delivery plan conflicted with other robots")
+
+	errStaleShippingIndex = errors.New("shipping index is stale")
 )
 
 type RobotService struct {
-	store *repository.Store
+	store    *repository.Store
+	timeouts config.TimeoutsConfig
+	logger   *slog.Logger
+	metrics  *metrics.Metrics
+	// 索引を最後に DB と突き合わせた時刻 (UnixNano)
+	lastIndexCheck atomic.Int64
 }
 
-func NewRobotService(store *repository.Store) *RobotService {
-	return &RobotService{store: store}
+func NewRobotService(store *repository.Store, timeouts config.TimeoutsConfig, logger *slog.Logger, m *metrics.Metrics) *RobotService {
+	return &RobotService{store: store, timeouts: timeouts, logger: logger, metrics: m}
 }
 
-func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string, capacity int) (*model.DeliveryPlan, error) {
+func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string, capacity int) (_ *model.DeliveryPlan, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "service.robot", "RobotService.GenerateDeliveryPlan",
+		attribute.String("robot.id", robotID),
+		attribute.Int("robot.capacity", capacity),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	var plan model.DeliveryPlan
 
-	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
-		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-			orders, err := txStore.OrderRepo.GetShippingOrdersOptimized(ctx, capacity)
-			if err != nil {
-				return err
-			}
-			plan, err = selectOrdersForDelivery(ctx, orders, robotID, capacity)
+	err = utils.WithTimeout(ctx, s.timeouts.DeliveryPlan.Std(), func(ctx context.Context) error {
+		if _, err := s.refreshIndex(ctx, shippingIndexVerifyInterval); err != nil {
+			return err
+		}
+		verifiedEmpty := false
+		for attempt := 1; ; attempt++ {
+			var claimed bool
+			var err error
+			plan, claimed, err = s.planFromIndex(ctx, robotID, capacity)
 			if err != nil {
 				return err
 			}
-
-			if len(plan.Orders) > 0 {
-				orderIDs := make([]int64, len(plan.Orders))
-				for i, order := range plan.Orders {
-					orderIDs[i] = order.OrderID
-				}
-
-				if err := txStore.OrderRepo.UpdateStatuses(ctx, orderIDs, "delivering"); err != nil {
+			if claimed && len(plan.Orders) == 0 && !verifiedEmpty {
+				// 索引が古いだけで DB には配送待ちの注文があるかもしれない
+				verifiedEmpty = true
+				reloaded, err := s.refreshIndex(ctx, emptyPlanVerifyInterval)
+				if err != nil {
 					return err
 				}
-				log.Printf("Updated status to 'delivering' for %d orders", len(orderIDs))
+				if reloaded {
+					continue
+				}
 			}
-			return nil
-		})
+			if claimed {
+				return nil
+			}
+			span.AddEvent("plan conflicted", trace.WithAttributes(attribute.Int("delivery.attempt", attempt)))
+			if attempt >= maxPlanAttempts {
+				return ErrPlanConflict
+			}
+		}
 	})
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttributes(
+		attribute.Int("delivery.selected_orders", len(plan.Orders)),
+		attribute.Int("delivery.total_value", plan.TotalValue),
+		attribute.Int("delivery.total_weight", plan.TotalWeight),
+	)
+	if len(plan.Orders) > 0 {
+		span.AddEvent("orders assigned", trace.WithAttributes(attribute.Int("delivery.selected_orders", len(plan.Orders))))
+		s.logger.InfoContext(ctx, "orders assigned to robot", "robot_id", robotID, "count", len(plan.Orders), "total_weight", plan.TotalWeight)
+	}
 	return &plan, nil
 }
 
-func (s *RobotService) UpdateOrderStatus(ctx context.Context, orderID int64, newStatus string) error {
-	return utils.WithTimeout(ctx, func(ctx context.Context) error {
-		return s.store.OrderRepo.UpdateStatuses(ctx, []int64{orderID}, newStatus)
+// 索引から配送計画を立て、選んだ注文を DB で配送中にする
+// 他のロボットと注文を取り合った、または索引が古かった場合は claimed を false にする
+func (s *RobotService) planFromIndex(ctx context.Context, robotID string, capacity int) (_ model.DeliveryPlan, claimed bool, _ error) {
+	orders := s.store.ShippingIndex.Candidates(capacity)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("delivery.candidate_orders", len(orders)))
+
+	start := time.Now()
+	plan, err := selectOrdersForDelivery(ctx, orders, robotID, capacity)
+	if err != nil {
+		return plan, false, err
+	}
+	s.metrics.ObserveDeliveryPlan(time.Since(start), len(plan.Orders))
+	if len(plan.Orders) == 0 {
+		return plan, true, nil
+	}
+
+	orderIDs := make([]int64, len(plan.Orders))
+	for i, order := range plan.Orders {
+		orderIDs[i] = order.OrderID
+	}
+	// DB に書き込む前に索引から外し、同時に計画を立てている他のロボットに選ばせない
+	if _, ok := s.store.ShippingIndex.Take(orderIDs); !ok {
+		return plan, false, nil
+	}
+
+	var stale bool
+	err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		n, err := txStore.OrderRepo.ClaimShippingOrders(ctx, orderIDs)
+		if err != nil {
+			return err
+		}
+		if n != int64(len(orderIDs)) {
+			stale = true
+			return errStaleShippingIndex
+		}
+		return nil
+	})
+	if stale {
+		// 索引の外で注文が変更されていた。作り直してから計画をやり直す
+		s.logger.WarnContext(ctx, "shipping index is stale; reloading", "robot_id", robotID)
+		s.store.ShippingIndex.Release(orderIDs...)
+		if err := s.store.LoadShippingIndex(ctx); err != nil {
+			return plan, false, err
+		}
+		return plan, false, nil
+	}
+	if err != nil {
+		// 取り出している間の商品の変更を反映した値で戻す
+		s.store.ShippingIndex.Restore(orderIDs...)
+		return plan, false, err
+	}
+	return plan, true, nil
+}
+
+// 索引が読み込まれていなければ読み込み、前回から minInterval 以上経っていれば DB と突き合わせる
+// リストアや SQL での直接の書き換えで索引だけが古くなっていたら読み直し、reloaded を true にする
+func (s *RobotService) refreshIndex(ctx context.Context, minInterval time.Duration) (reloaded bool, _ error) {
+	if !s.store.ShippingIndex.Loaded() {
+		s.lastIndexCheck.Store(time.Now().UnixNano())
+		return true, s.store.LoadShippingIndex(ctx)
+	}
+	now := time.Now().UnixNano()
+	last := s.lastIndexCheck.Load()
+	if now-last < int64(minInterval) || !s.lastIndexCheck.CompareAndSwap(last, now) {
+		return false, nil
+	}
+	fresh, err := s.store.ShippingIndexFresh(ctx)
+	if err != nil || fresh {
+		return false, err
+	}
+	s.logger.WarnContext(ctx, "shipping index does not match the database; reloading")
+	return true, s.store.LoadShippingIndex(ctx)
+}
+
+func (s *RobotService) UpdateOrderStatus(ctx context.Context, orderID int64, newStatus string) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "service.robot", "RobotService.UpdateOrderStatus",
+		attribute.Int64("order.id", orderID),
+		attribute.String("order.new_status", newStatus),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	return utils.WithTimeout(ctx, s.timeouts.UpdateOrderStatus.Std(), func(ctx context.Context) error {
+		// ステータス・配送待ちキャッシュ・注文数をまとめて更新する
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			return txStore.OrderRepo.UpdateStatuses(ctx, []int64{orderID}, newStatus)
+		})
 	})
 }
 
-func selectOrdersForDelivery(_ context.Context, orders []model.Order, robotID string, robotCapacity int) (model.DeliveryPlan, error) {
+func selectOrdersForDelivery(ctx context.Context, orders []model.Order, robotID string, robotCapacity int) (_ model.DeliveryPlan, err error) {
 	n := len(orders)
+	// DP テーブルの大きさが計算時間とメモリ使用量をほぼ決める
+	_, span := telemetry.StartSpan(ctx, "service.robot", "selectOrdersForDelivery",
+		attribute.Int("delivery.candidate_orders", n),
+		attribute.Int("robot.capacity", robotCapacity),
+		attribute.Int64("delivery.dp_cells", int64(n+1)*int64(robotCapacity+1)),
+	)
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	if n == 0 {
 		return model.DeliveryPlan{
 			RobotID:     robotID,
@@ -73,6 +217,12 @@ func selectOrdersForDelivery(_ context.Context, orders []model.Order, robotID st
 	}
 
 	for i := 1; i <= n; i++ {
+		// 計算量が大きいため、制限時間を超えたら打ち切る
+		if i%256 == 0 {
+			if err := ctx.Err(); err != nil {
+				return model.DeliveryPlan{}, err
+			}
+		}
 		order := orders[i-1]
 		for w := 0; w <= robotCapacity; w++ {
 			dp[i][w] = dp[i-1][w]
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"backend/internal/metrics"
+	"backend/internal/model"
+	"backend/internal/repository"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// ログに並べる注文IDの上限
+const maxLoggedDriftIDs = 20
+
+// shipping_order_cache のずれの検出と修復
+type ShippingCacheService struct {
+	store   *repository.Store
+	logger  *slog.Logger
+	metrics *metrics.Metrics
+}
+
+func NewShippingCacheService(store *repository.Store, logger *slog.Logger, m *metrics.Metrics) *ShippingCacheService {
+	return &ShippingCacheService{store: store, logger: logger, metrics: m}
+}
+
+// ずれを調べて報告する。キャッシュは変更しない
+func (s *ShippingCacheService) Check(ctx context.Context) (model.ShippingCacheDrift, error) {
+	drift, err := s.store.OrderRepo.CheckShippingCache(ctx)
+	if err != nil {
+		return drift, err
+	}
+	s.report(ctx, drift)
+	return drift, nil
+}
+
+// ずれを調べて直す。確認と修復は同じトランザクションで行う
+func (s *ShippingCacheService) Repair(ctx context.Context) (model.ShippingCacheDrift, int64, error) {
+	var drift model.ShippingCacheDrift
+	var repaired int64
+	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		var err error
+		drift, err = txStore.OrderRepo.CheckShippingCache(ctx)
+		if err != nil {
+			return err
+		}
+		if drift.Total() == 0 {
+			return nil
+		}
+		repaired, err = txStore.OrderRepo.RepairShippingCache(ctx, drift)
+		return err
+	})
+	if err != nil {
+		return drift, 0, err
+	}
+	s.report(ctx, drift)
+	if repaired > 0 {
+		s.metrics.AddShippingCacheRepairs(repaired)
+		s.logger.InfoContext(ctx, "shipping_order_cache repaired", "rows", repaired)
+		// 索引も同じずれを抱えているので作り直す
+		if s.store.ShippingIndex.Loaded() {
+			if err := s.store.LoadShippingIndex(ctx); err != nil {
+				return drift, repaired, err
+			}
+		}
+	}
+	return drift, repaired, nil
+}
+
+// interval ごとに確認し、repair なら見つかったずれを直す。ctx が終わるまで戻らない
+func (s *ShippingCacheService) RunPeriodically(ctx context.Context, interval time.Duration, repair bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		var err error
+		if repair {
+			_, _, err = s.Repair(ctx)
+		} else {
+			_, err = s.Check(ctx)
+		}
+		if err != nil && ctx.Err() == nil {
+			s.logger.ErrorContext(ctx, "shipping_order_cache check failed", "error", err)
+		}
+	}
+}
+
+func (s *ShippingCacheService) report(ctx context.Context, drift model.ShippingCacheDrift) {
+	s.metrics.SetShippingCacheDrift(len(drift.Missing), len(drift.Stale), len(drift.Orphaned))
+	if drift.Total() == 0 {
+		s.logger.DebugContext(ctx, "shipping_order_cache is consistent")
+		return
+	}
+	s.logger.WarnContext(ctx, "shipping_order_cache drift detected",
+		"missing", len(drift.Missing),
+		"stale", len(drift.Stale),
+		"orphaned", len(drift.Orphaned),
+		"missing_ids", drift.Missing[:min(len(drift.Missing), maxLoggedDriftIDs)],
+		"stale_ids", drift.Stale[:min(len(drift.Stale), maxLoggedDriftIDs)],
+		"orphaned_ids", drift.Orphaned[:min(len(drift.Orphaned), maxLoggedDriftIDs)],
+	)
+}
@@ -1,8 +1,29 @@
 package utils
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
 
-// WithTimeout は呼び出し先の実装に委ねるため、親コンテキストをそのまま渡して実行する
-func WithTimeout(parent context.Context, fn func(ctx context.Context) error) error {
-	return fn(parent)
+// 処理が制限時間を超えたことを表す。ハンドラーで 504 に変換する
+var ErrTimeout = errors.New("operation timed out")
+
+// WithTimeout は制限時間付きのコンテキストで fn を実行する
+// 制限時間(または親コンテキストの期限)を超えて失敗した場合は ErrTimeout でラップして返す
+// timeout が 0 以下なら親コンテキストをそのまま渡す
+func WithTimeout(parent context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	ctx := parent
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, timeout)
+		defer cancel()
+	}
+
+	err := fn(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+	return err
 }
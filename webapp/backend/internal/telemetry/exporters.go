@@ -0,0 +1,153 @@
+package telemetry
+
+import (
+	"backend/internal/config"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutlog"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const protocolGRPC = "grpc"
+
+func (t *Telemetry) spanExporter(ctx context.Context, cfg config.TelemetryConfig, name string) (sdktrace.SpanExporter, error) {
+	switch name {
+	case config.ExporterOTLP:
+		host, insecure, err := parseOTLPEndpoint(cfg.OTLPEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.OTLPProtocol == protocolGRPC {
+			opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(host)}
+			if insecure {
+				opts = append(opts, otlptracegrpc.WithInsecure())
+			}
+			return otlptracegrpc.New(ctx, opts...)
+		}
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(host)}
+		if insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case config.ExporterStdout, config.ExporterFile:
+		w, err := t.writer(cfg, name, "traces.jsonl")
+		if err != nil {
+			return nil, err
+		}
+		return stdouttrace.New(stdouttrace.WithWriter(w))
+	}
+	return nil, fmt.Errorf("unknown traces exporter: %q", name)
+}
+
+func (t *Telemetry) metricExporter(ctx context.Context, cfg config.TelemetryConfig, name string) (sdkmetric.Exporter, error) {
+	switch name {
+	case config.ExporterOTLP:
+		host, insecure, err := parseOTLPEndpoint(cfg.OTLPEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.OTLPProtocol == protocolGRPC {
+			opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(host)}
+			if insecure {
+				opts = append(opts, otlpmetricgrpc.WithInsecure())
+			}
+			return otlpmetricgrpc.New(ctx, opts...)
+		}
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(host)}
+		if insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	case config.ExporterStdout, config.ExporterFile:
+		w, err := t.writer(cfg, name, "metrics.jsonl")
+		if err != nil {
+			return nil, err
+		}
+		return stdoutmetric.New(stdoutmetric.WithWriter(w))
+	}
+	return nil, fmt.Errorf("unknown metrics exporter: %q", name)
+}
+
+func (t *Telemetry) logExporter(ctx context.Context, cfg config.TelemetryConfig, name string) (sdklog.Exporter, error) {
+	switch name {
+	case config.ExporterOTLP:
+		host, insecure, err := parseOTLPEndpoint(cfg.OTLPEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.OTLPProtocol == protocolGRPC {
+			opts := []otlploggrpc.Option{otlploggrpc.WithEndpoint(host)}
+			if insecure {
+				opts = append(opts, otlploggrpc.WithInsecure())
+			}
+			return otlploggrpc.New(ctx, opts...)
+		}
+		opts := []otlploghttp.Option{otlploghttp.WithEndpoint(host)}
+		if insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		return otlploghttp.New(ctx, opts...)
+	case config.ExporterStdout, config.ExporterFile:
+		w, err := t.writer(cfg, name, "logs.jsonl")
+		if err != nil {
+			return nil, err
+		}
+		return stdoutlog.New(stdoutlog.WithWriter(w))
+	}
+	return nil, fmt.Errorf("unknown logs exporter: %q", name)
+}
+
+// stdout ならそのまま標準出力を、file なら FileDir 以下のファイルを追記モードで開いて返す
+// ファイルはプロバイダーの停止後に閉じる
+func (t *Telemetry) writer(cfg config.TelemetryConfig, exporter, file string) (io.Writer, error) {
+	if exporter == config.ExporterStdout {
+		return os.Stdout, nil
+	}
+	if err := os.MkdirAll(cfg.FileDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create telemetry directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(cfg.FileDir, file), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open telemetry file: %w", err)
+	}
+	t.onShutdown(func(context.Context) error { return f.Close() })
+	return f, nil
+}
+
+// "host:port" または "http(s)://host:port[/path]" を受け付け、ホストと TLS を使わないかどうかを返す
+// パスは無視し、シグナルごとの既定のパス(/v1/traces など)を使う
+func parseOTLPEndpoint(endpoint string) (host string, insecure bool, err error) {
+	if !strings.Contains(endpoint, "://") {
+		if endpoint == "" {
+			return "", false, fmt.Errorf("otlp endpoint is empty")
+		}
+		return endpoint, true, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid otlp endpoint %q: %w", endpoint, err)
+	}
+	switch u.Scheme {
+	case "http":
+		return u.Host, true, nil
+	case "https":
+		return u.Host, false, nil
+	}
+	return "", false, fmt.Errorf("invalid otlp endpoint scheme %q", u.Scheme)
+}
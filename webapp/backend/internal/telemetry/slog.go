@@ -0,0 +1,109 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	otellog "go.opentelemetry.io/otel/log"
+)
+
+// slog のレコードを OpenTelemetry のログとして送る slog.Handler
+// トレースIDはコンテキストのスパンから SDK が付与する
+type slogHandler struct {
+	logger otellog.Logger
+	attrs  []otellog.KeyValue
+	// WithGroup で指定されたグループ名。キーの接頭辞にする
+	prefix string
+}
+
+func NewSlogHandler(logger otellog.Logger) slog.Handler {
+	return &slogHandler{logger: logger}
+}
+
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.logger.Enabled(ctx, otellog.EnabledParameters{Severity: severity(level)})
+}
+
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var rec otellog.Record
+	rec.SetTimestamp(r.Time)
+	rec.SetObservedTimestamp(time.Now())
+	rec.SetSeverity(severity(r.Level))
+	rec.SetSeverityText(r.Level.String())
+	rec.SetBody(otellog.StringValue(r.Message))
+	rec.AddAttributes(h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		rec.AddAttributes(convertAttr(h.prefix, a)...)
+		return true
+	})
+	h.logger.Emit(ctx, rec)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]otellog.KeyValue(nil), h.attrs...)
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, convertAttr(h.prefix, a)...)
+	}
+	return &c
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// slog のレベルを OpenTelemetry の重要度に変換する
+// DEBUG(-4) → DEBUG, INFO(0) → INFO, WARN(4) → WARN, ERROR(8) → ERROR
+func severity(level slog.Level) otellog.Severity {
+	return otellog.Severity(level + 9)
+}
+
+func convertAttr(prefix string, a slog.Attr) []otellog.KeyValue {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return nil
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		p := prefix
+		if a.Key != "" {
+			p += a.Key + "."
+		}
+		var kvs []otellog.KeyValue
+		for _, ga := range a.Value.Group() {
+			kvs = append(kvs, convertAttr(p, ga)...)
+		}
+		return kvs
+	}
+	return []otellog.KeyValue{{Key: prefix + a.Key, Value: convertValue(a.Value)}}
+}
+
+func convertValue(v slog.Value) otellog.Value {
+	switch v.Kind() {
+	case slog.KindString:
+		return otellog.StringValue(v.String())
+	case slog.KindInt64:
+		return otellog.Int64Value(v.Int64())
+	case slog.KindUint64:
+		return otellog.Int64Value(int64(v.Uint64()))
+	case slog.KindFloat64:
+		return otellog.Float64Value(v.Float64())
+	case slog.KindBool:
+		return otellog.BoolValue(v.Bool())
+	case slog.KindDuration:
+		return otellog.StringValue(v.Duration().String())
+	case slog.KindTime:
+		return otellog.StringValue(v.Time().Format(time.RFC3339Nano))
+	}
+	if err, ok := v.Any().(error); ok {
+		return otellog.StringValue(err.Error())
+	}
+	return otellog.StringValue(fmt.Sprint(v.Any()))
+}
@@ -1,27 +1,26 @@
 package telemetry
 
 import (
-	"database/sql"
-	"log"
+	"backend/internal/config"
+	"log/slog"
 
 	"github.com/XSAM/otelsql"
-	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
 )
 
-func WrapSQLDriver(baseDriver string) string {
-	if !enabled() {
+func WrapSQLDriver(baseDriver string, cfg config.TelemetryConfig) string {
+	// トレースかメトリクスのどちらかが有効なら計装する
+	if !cfg.IsEnabled() && !signalEnabled(cfg.MetricsExporter) {
 		return baseDriver
 	}
 	name, err := otelsql.Register(baseDriver,
-		otelsql.WithAttributes(semconv.DBSystemKey.String(baseDriver)),
+		otelsql.WithAttributes(semconv.DBSystemNameKey.String(baseDriver)),
 		otelsql.WithSQLCommenter(true),
 		otelsql.WithSpanOptions(otelsql.SpanOptions{DisableErrSkip: true}),
 	)
 	if err != nil {
-		log.Printf("otelsql.Register failed, fallback to base driver: %v", err)
+		slog.Warn("otelsql.Register failed, falling back to base driver", "driver", baseDriver, "error", err)
 		return baseDriver
 	}
 	return name
 }
-
-var _ = sql.ErrNoRows
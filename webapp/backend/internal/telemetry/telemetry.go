@@ -1,44 +1,151 @@
-// internal/telemetry/telemetry.go
+// OpenTelemetry のトレース・メトリクス・ログの初期化と停止をまとめる
+// 各シグナルのエクスポーターは config.TelemetryConfig で選ぶ
 package telemetry
 
 import (
+	"backend/internal/config"
 	"context"
-	"os"
-	"strconv"
+	"errors"
+	"log/slog"
+	"slices"
 	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
-	"go.opentelemetry.io/otel/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
-func enabled() bool {
-	if strings.EqualFold(os.Getenv("TRACE_ENABLED"), "false") {
-		return false
+// メトリクスをエクスポートする間隔
+const metricInterval = 30 * time.Second
+
+// 初期化したプロバイダーを保持し、まとめて停止する
+type Telemetry struct {
+	shutdowns  []func(context.Context) error
+	logHandler slog.Handler
+}
+
+// 設定に従ってプロバイダーを作り、グローバルに登録する
+// 無効なシグナルは no-op のままにする
+func Init(ctx context.Context, cfg config.TelemetryConfig) (*Telemetry, error) {
+	t := &Telemetry{}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	res := resourceFrom(cfg)
+
+	if err := t.initTraces(ctx, cfg, res); err != nil {
+		return nil, errors.Join(err, t.Shutdown(ctx))
+	}
+	if err := t.initMetrics(ctx, cfg, res); err != nil {
+		return nil, errors.Join(err, t.Shutdown(ctx))
+	}
+	if err := t.initLogs(ctx, cfg, res); err != nil {
+		return nil, errors.Join(err, t.Shutdown(ctx))
+	}
+	return t, nil
+}
+
+func (t *Telemetry) initTraces(ctx context.Context, cfg config.TelemetryConfig, res *resource.Resource) error {
+	name := cfg.TraceExporter()
+	if name == config.ExporterNone {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return nil
+	}
+	exp, err := t.spanExporter(ctx, cfg, name)
+	if err != nil {
+		return err
 	}
-	if strings.EqualFold(os.Getenv("TRACE_ENABLED"), "true") {
-		return true
+	tp := newTracerProvider(cfg, res, sdktrace.WithBatcher(exp,
+		sdktrace.WithMaxQueueSize(4096),
+		sdktrace.WithExportTimeout(5*time.Second),
+	))
+	otel.SetTracerProvider(tp)
+	t.onShutdown(tp.Shutdown)
+	return nil
+}
+
+func (t *Telemetry) initMetrics(ctx context.Context, cfg config.TelemetryConfig, res *resource.Resource) error {
+	if !signalEnabled(cfg.MetricsExporter) {
+		return nil
 	}
-	if os.Getenv("JAEGER_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
-		return true
+	exp, err := t.metricExporter(ctx, cfg, cfg.MetricsExporter)
+	if err != nil {
+		return err
 	}
-	return false
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exp, sdkmetric.WithInterval(metricInterval))),
+	)
+	otel.SetMeterProvider(mp)
+	t.onShutdown(mp.Shutdown)
+	return nil
 }
 
-func samplerFromEnv() sdktrace.Sampler {
-	if r := os.Getenv("TRACE_SAMPLE_RATIO"); r != "" {
-		if v, err := strconv.ParseFloat(r, 64); err == nil {
-			return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(v))
+func (t *Telemetry) initLogs(ctx context.Context, cfg config.TelemetryConfig, res *resource.Resource) error {
+	if !signalEnabled(cfg.LogsExporter) {
+		return nil
+	}
+	exp, err := t.logExporter(ctx, cfg, cfg.LogsExporter)
+	if err != nil {
+		return err
+	}
+	lp := sdklog.NewLoggerProvider(
+		sdklog.WithResource(res),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(exp)),
+	)
+	global.SetLoggerProvider(lp)
+	t.logHandler = NewSlogHandler(lp.Logger(cfg.ServiceName))
+	t.onShutdown(lp.Shutdown)
+	return nil
+}
+
+// ログのエクスポートが有効な場合に slog の出力先として追加するハンドラー。無効なら nil
+func (t *Telemetry) LogHandler() slog.Handler {
+	return t.logHandler
+}
+
+// 送信待ちのデータを送ってからプロバイダーを停止する
+// 登録と逆の順に停止し、エラーはまとめて返す
+func (t *Telemetry) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, fn := range slices.Backward(t.shutdowns) {
+		if err := fn(ctx); err != nil {
+			errs = append(errs, err)
 		}
 	}
-	switch strings.ToLower(os.Getenv("OTEL_TRACES_SAMPLER")) {
+	t.shutdowns = nil
+	return errors.Join(errs...)
+}
+
+func (t *Telemetry) onShutdown(fn func(context.Context) error) {
+	t.shutdowns = append(t.shutdowns, fn)
+}
+
+func signalEnabled(exporter string) bool {
+	return exporter != "" && exporter != config.ExporterNone
+}
+
+// TracerProvider を作る。テストではエクスポーターの代わりに SpanRecorder を渡す
+func newTracerProvider(cfg config.TelemetryConfig, res *resource.Resource, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(samplerFrom(cfg)),
+		sdktrace.WithResource(res),
+	}, opts...)
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+func samplerFrom(cfg config.TelemetryConfig) sdktrace.Sampler {
+	if cfg.SampleRatio != nil {
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*cfg.SampleRatio))
+	}
+	switch strings.ToLower(cfg.Sampler) {
 	case "always_off":
 		return sdktrace.NeverSample()
 	case "always_on":
@@ -47,63 +154,14 @@ func samplerFromEnv() sdktrace.Sampler {
 	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(0.01))
 }
 
-func resourceFromEnv() *resource.Resource {
-	svc := os.Getenv("SERVICE_NAME")
-	if svc == "" {
-		svc = "backend"
-	}
-	env := os.Getenv("ENV")
-	if env == "" {
-		env = os.Getenv("GO_ENV")
-		if env == "" {
-			env = "local"
-		}
-	}
+func resourceFrom(cfg config.TelemetryConfig) *resource.Resource {
 	r, _ := resource.Merge(
 		resource.Default(),
 		resource.NewWithAttributes(
 			semconv.SchemaURL,
-			semconv.ServiceName(svc),
-			attribute.String("deployment.environment", env),
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.DeploymentEnvironmentName(cfg.Environment),
 		),
 	)
 	return r
 }
-
-func Init(ctx context.Context) (func(context.Context) error, error) {
-	if !enabled() {
-		otel.SetTracerProvider(trace.NewNoopTracerProvider())
-		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-			propagation.TraceContext{}, propagation.Baggage{},
-		))
-		return func(context.Context) error { return nil }, nil
-	}
-
-	var (
-		exp sdktrace.SpanExporter
-		err error
-	)
-	if ep := os.Getenv("JAEGER_ENDPOINT"); ep != "" {
-		exp, err = jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(ep)))
-	} else if ep := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); ep != "" {
-		exp, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpoint(ep), otlptracehttp.WithInsecure())
-	}
-	if err != nil || exp == nil {
-		otel.SetTracerProvider(trace.NewNoopTracerProvider())
-		return func(context.Context) error { return nil }, nil
-	}
-
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithSampler(samplerFromEnv()),
-		sdktrace.WithBatcher(exp,
-			sdktrace.WithMaxQueueSize(4096),
-			sdktrace.WithExportTimeout(5*time.Second),
-		),
-		sdktrace.WithResource(resourceFromEnv()),
-	)
-	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{}, propagation.Baggage{},
-	))
-	return tp.Shutdown, nil
-}
@@ -0,0 +1,263 @@
+package telemetry
+
+import (
+	"backend/internal/config"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// SpanRecorder に記録する TracerProvider を作る
+func newRecordingProvider(t *testing.T, cfg config.TelemetryConfig) (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := newTracerProvider(cfg, resourceFrom(cfg), sdktrace.WithSpanProcessor(rec))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	return tp, rec
+}
+
+func TestTracerProviderRecordsSpansWithResource(t *testing.T) {
+	cfg := config.TelemetryConfig{Sampler: "always_on", ServiceName: "backend-test", Environment: "test"}
+	tp, rec := newRecordingProvider(t, cfg)
+
+	_, span := tp.Tracer("test").Start(context.Background(), "operation")
+	span.End()
+
+	spans := rec.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("ended spans = %d, want 1", len(spans))
+	}
+	if got := spans[0].Name(); got != "operation" {
+		t.Errorf("span name = %q, want %q", got, "operation")
+	}
+	attrs := spans[0].Resource().Set()
+	if v, ok := attrs.Value(semconv.ServiceNameKey); !ok || v.AsString() != "backend-test" {
+		t.Errorf("service.name = %q, want %q", v.AsString(), "backend-test")
+	}
+	if v, ok := attrs.Value(semconv.DeploymentEnvironmentNameKey); !ok || v.AsString() != "test" {
+		t.Errorf("deployment.environment.name = %q, want %q", v.AsString(), "test")
+	}
+}
+
+func TestSamplerFrom(t *testing.T) {
+	sampledParent := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+
+	tests := []struct {
+		name   string
+		cfg    config.TelemetryConfig
+		parent context.Context
+		want   int
+	}{
+		{"always_on", config.TelemetryConfig{Sampler: "always_on"}, context.Background(), 1},
+		{"always_off", config.TelemetryConfig{Sampler: "always_off"}, context.Background(), 0},
+		{"ratio 1", config.TelemetryConfig{SampleRatio: ptr(1.0)}, context.Background(), 1},
+		{"ratio 0", config.TelemetryConfig{SampleRatio: ptr(0.0)}, context.Background(), 0},
+		// 既定は親のサンプリング判定に従う
+		{"ratio 0 with sampled parent", config.TelemetryConfig{SampleRatio: ptr(0.0)}, sampledParent, 1},
+		{"default with sampled parent", config.TelemetryConfig{}, sampledParent, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp, rec := newRecordingProvider(t, tt.cfg)
+			_, span := tp.Tracer("test").Start(tt.parent, "operation")
+			span.End()
+			if got := len(rec.Ended()); got != tt.want {
+				t.Errorf("recorded spans = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// otelchi のスパンがルートパターン名で記録され、ハンドラー内のスパンがその子になること
+func TestOtelchiSpansUseRoutePattern(t *testing.T) {
+	tp, rec := newRecordingProvider(t, config.TelemetryConfig{Sampler: "always_on"})
+
+	r := chi.NewRouter()
+	r.Use(otelchi.Middleware("backend-api", otelchi.WithChiRoutes(r), otelchi.WithTracerProvider(tp)))
+	r.Get("/api/admin/products/{productID}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tp.Tracer("handler").Start(r.Context(), "ProductService.GetProduct")
+		span.End()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/admin/products/42", nil))
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("ended spans = %d, want 2", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if got, want := server.Name(), "/api/admin/products/{productID}"; got != want {
+		t.Errorf("server span name = %q, want %q", got, want)
+	}
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span kind = %v, want %v", server.SpanKind(), trace.SpanKindServer)
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("handler span is not a child of the server span")
+	}
+}
+
+func TestEndSpanRecordsError(t *testing.T) {
+	tp, rec := newRecordingProvider(t, config.TelemetryConfig{Sampler: "always_on"})
+
+	_, ok := tp.Tracer("test").Start(context.Background(), "ok")
+	EndSpan(ok, nil)
+	_, failed := tp.Tracer("test").Start(context.Background(), "failed")
+	EndSpan(failed, errors.New("boom"))
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("ended spans = %d, want 2", len(spans))
+	}
+	if got := spans[0].Status().Code; got != codes.Unset {
+		t.Errorf("status of successful span = %v, want %v", got, codes.Unset)
+	}
+	if got := spans[1].Status(); got.Code != codes.Error || got.Description != "boom" {
+		t.Errorf("status of failed span = %+v, want error %q", got, "boom")
+	}
+	if events := spans[1].Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Errorf("failed span events = %v, want one exception event", events)
+	}
+}
+
+// テスト用にエクスポートされたログを保持する
+type memoryLogExporter struct {
+	mu      sync.Mutex
+	records []sdklog.Record
+}
+
+func (e *memoryLogExporter) Export(_ context.Context, records []sdklog.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range records {
+		e.records = append(e.records, r.Clone())
+	}
+	return nil
+}
+
+func (e *memoryLogExporter) Shutdown(context.Context) error   { return nil }
+func (e *memoryLogExporter) ForceFlush(context.Context) error { return nil }
+
+func TestSlogHandlerEmitsRecordsWithTraceContext(t *testing.T) {
+	exp := &memoryLogExporter{}
+	lp := sdklog.NewLoggerProvider(sdklog.WithProcessor(sdklog.NewSimpleProcessor(exp)))
+	t.Cleanup(func() { _ = lp.Shutdown(context.Background()) })
+	logger := slog.New(NewSlogHandler(lp.Logger("test")))
+
+	tp, _ := newRecordingProvider(t, config.TelemetryConfig{Sampler: "always_on"})
+	ctx, span := tp.Tracer("test").Start(context.Background(), "operation")
+	logger.With("component", "auth").WithGroup("req").WarnContext(ctx, "login failed", "user_id", 7)
+	span.End()
+
+	if len(exp.records) != 1 {
+		t.Fatalf("exported records = %d, want 1", len(exp.records))
+	}
+	rec := exp.records[0]
+	if got := rec.Body().AsString(); got != "login failed" {
+		t.Errorf("body = %q, want %q", got, "login failed")
+	}
+	if rec.Severity() != otellog.SeverityWarn {
+		t.Errorf("severity = %v, want %v", rec.Severity(), otellog.SeverityWarn)
+	}
+	if rec.TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("trace id = %s, want %s", rec.TraceID(), span.SpanContext().TraceID())
+	}
+
+	attrs := map[string]otellog.Value{}
+	rec.WalkAttributes(func(kv otellog.KeyValue) bool {
+		attrs[kv.Key] = kv.Value
+		return true
+	})
+	if got := attrs["component"].AsString(); got != "auth" {
+		t.Errorf("component = %q, want %q", got, "auth")
+	}
+	if got := attrs["req.user_id"].AsInt64(); got != 7 {
+		t.Errorf("req.user_id = %d, want 7", got)
+	}
+}
+
+func TestInitWithFileExporter(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	dir := t.TempDir()
+	cfg := config.TelemetryConfig{
+		TracesExporter: config.ExporterFile,
+		FileDir:        dir,
+		Sampler:        "always_on",
+		ServiceName:    "backend-test",
+	}
+	tel, err := Init(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if tel.LogHandler() != nil {
+		t.Errorf("LogHandler should be nil when logs are disabled")
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "file-export")
+	span.End()
+	if err := tel.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "traces.jsonl"))
+	if err != nil {
+		t.Fatalf("read traces file: %v", err)
+	}
+	if len(data) == 0 {
+		t.Fatal("traces file is empty")
+	}
+}
+
+func TestParseOTLPEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint     string
+		wantHost     string
+		wantInsecure bool
+		wantErr      bool
+	}{
+		{"jaeger:4318", "jaeger:4318", true, false},
+		{"http://jaeger:4318", "jaeger:4318", true, false},
+		{"https://collector.example.com", "collector.example.com", false, false},
+		{"http://jaeger:14268/api/traces", "jaeger:14268", true, false},
+		{"ftp://jaeger:4318", "", false, true},
+		{"", "", false, true},
+	}
+	for _, tt := range tests {
+		host, insecure, err := parseOTLPEndpoint(tt.endpoint)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseOTLPEndpoint(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+			continue
+		}
+		if host != tt.wantHost || insecure != tt.wantInsecure {
+			t.Errorf("parseOTLPEndpoint(%q) = (%q, %v), want (%q, %v)", tt.endpoint, host, insecure, tt.wantHost, tt.wantInsecure)
+		}
+	}
+}
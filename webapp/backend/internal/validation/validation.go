@@ -0,0 +1,120 @@
+// リクエスト型に付けた validate タグを検証する
+// タグの書式は github.com/go-playground/validator に従う
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// エラーのフィールド名はクライアントが送る JSON のキーにそろえる
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+	return v
+}
+
+// 1フィールド分の検証エラー
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// 検証に失敗したフィールドの一覧
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// 構造体の validate タグを検証する。失敗した場合は Errors を返す
+func Struct(v any) error {
+	return convert(validate.Struct(v), "")
+}
+
+// 単一の値を検証する。field はエラーに載せるフィールド名
+func Var(field string, value any, tag string) error {
+	return convert(validate.Var(value, tag), field)
+}
+
+func convert(err error, field string) error {
+	if err == nil {
+		return nil
+	}
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return err
+	}
+	out := make(Errors, 0, len(verrs))
+	for _, fe := range verrs {
+		name := field
+		if name == "" {
+			name = fieldPath(fe.Namespace())
+		}
+		out = append(out, FieldError{
+			Field:   name,
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: message(fe),
+		})
+	}
+	return out
+}
+
+// "ListRequest.items[0].quantity" のような名前から先頭の型名を取り除く
+func fieldPath(ns string) string {
+	if _, rest, ok := strings.Cut(ns, "."); ok {
+		return rest
+	}
+	return ns
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		if isLength(fe.Kind()) {
+			return fmt.Sprintf("must contain at least %s item(s) or character(s)", fe.Param())
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if isLength(fe.Kind()) {
+			return fmt.Sprintf("must contain at most %s item(s) or character(s)", fe.Param())
+		}
+		return "must be at most " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "gte":
+		return "must be greater than or equal to " + fe.Param()
+	case "oneof", "oneofci":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	default:
+		return "failed on the '" + fe.Tag() + "' rule"
+	}
+}
+
+func isLength(k reflect.Kind) bool {
+	return k == reflect.String || k == reflect.Slice || k == reflect.Map || k == reflect.Array
+}
@@ -36,7 +36,7 @@ type OrdersRow = {
   };
 };
 
-type SearchType = "partial" | "prefix";
+type SearchType = "partial" | "prefix" | "fulltext";
 
 export default function OrdersPage() {
   const [ordersRow, setOrdersRow] = useState<OrdersRow[]>([]);
@@ -258,6 +258,12 @@ export default function OrdersPage() {
                   label="前方一致"
                   disabled={isLoading}
                 />
+                <FormControlLabel
+                  value="fulltext"
+                  control={<Radio size="small" />}
+                  label="全文検索"
+                  disabled={isLoading}
+                />
               </RadioGroup>
             </FormControl>
           </Box>